@@ -1,25 +1,68 @@
 package commands
 
 import (
+	"flag"
 	"fmt"
+	"os"
+	"path"
 	"path/filepath"
+	"strings"
 
 	"github.com/erkkah/git-private/utils"
 )
 
-func Add(files []string, _ func()) error {
+func Add(args []string, usage func()) error {
+	var config struct {
+		Untrack bool
+		Format  string
+	}
+
+	flags := flag.NewFlagSet("add [-untrack] [-format FORMAT] <file...>", flag.ExitOnError)
+	flags.BoolVar(&config.Untrack, "untrack", false, "Remove files that are already tracked by git from the index")
+	flags.StringVar(&config.Format, "format", "", "Encrypt values one by one, in `format` \"dotenv\", \"json\" or \"yaml\", or \"whole\" files")
+	flags.Usage = usage
+	flags.Parse(args)
+
 	err := utils.EnsureInitialized()
 	if err != nil {
 		return err
 	}
 
+	format, err := utils.ParseFormat(config.Format)
+	if err != nil {
+		return err
+	}
+
+	err = unlockFileList(nil)
+	if err != nil {
+		return err
+	}
+
+	files := flags.Args()
 	if len(files) == 0 {
 		return fmt.Errorf("no files to add")
 	}
 
 	var filesToAdd []utils.RepoRelativePath
+	var patternsToAdd []string
+	var trackedFiles []utils.RepoRelativePath
 
 	for _, file := range files {
+		if utils.IsPattern(file) {
+			pattern, err := repoRelativePattern(file)
+			if err != nil {
+				return err
+			}
+			patternsToAdd = append(patternsToAdd, pattern)
+
+			matching, err := trackedFilesMatching(pattern)
+			if err != nil {
+				return err
+			}
+			trackedFiles = append(trackedFiles, matching...)
+			continue
+		}
+
 		if !filepath.IsAbs(file) {
 			file, err = filepath.Abs(file)
 			if err != nil {
@@ -28,59 +71,267 @@ func Add(files []string, _ func()) error {
 		}
 
 		absolute := utils.AbsolutePath(file)
-		exists, err := utils.Exists(absolute)
+		info, err := os.Stat(absolute.Absolute())
+		if os.IsNotExist(err) {
+			return fmt.Errorf("no such file: %q", file)
+		}
 		if err != nil {
 			return err
 		}
-		if !exists {
-			return fmt.Errorf("no such file: %q", file)
-		}
 		repoRelative, err := utils.RepoRelative(absolute)
 		if err != nil {
 			return err
 		}
+
+		if info.IsDir() {
+			pattern := filepath.ToSlash(repoRelative.Relative()) + "/**"
+			patternsToAdd = append(patternsToAdd, pattern)
+
+			matching, err := trackedFilesMatching(pattern)
+			if err != nil {
+				return err
+			}
+			trackedFiles = append(trackedFiles, matching...)
+			continue
+		}
+
 		filesToAdd = append(filesToAdd, repoRelative)
+
+		tracked, err := utils.GitIsTracked(repoRelative)
+		if err != nil {
+			return err
+		}
+		if tracked {
+			trackedFiles = append(trackedFiles, repoRelative)
+		}
 	}
 
-	err = addFiles(filesToAdd)
+	trackedFiles = uniqueFiles(trackedFiles)
+
+	if len(trackedFiles) != 0 && !config.Untrack {
+		quoted := make([]string, len(trackedFiles))
+		for i, file := range trackedFiles {
+			quoted[i] = fmt.Sprintf("%q", file)
+		}
+		return fmt.Errorf("already tracked by git and would still be committed in plain text: %s, use 'untrack' flag to remove from the index",
+			strings.Join(quoted, ", "))
+	}
+
+	err = addFiles(filesToAdd, patternsToAdd, format, config.Format != "")
 	if err != nil {
 		return err
 	}
 
+	// Untracking last, so that files are not left untracked and unprotected
+	for _, file := range trackedFiles {
+		err = utils.GitUntrack(file)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "%q was removed from the git index, but is still present in the git history, see 'scan-history'\n", file)
+	}
+
 	return nil
 }
 
-func addFiles(files []utils.RepoRelativePath) error {
+func uniqueFiles(files []utils.RepoRelativePath) []utils.RepoRelativePath {
+	var unique []utils.RepoRelativePath
+	seen := map[utils.RepoRelativePath]bool{}
+	for _, file := range files {
+		if !seen[file] {
+			seen[file] = true
+			unique = append(unique, file)
+		}
+	}
+	return unique
+}
+
+// repoRelativePattern converts a pattern relative to the current
+// directory to a pattern relative to the repo root.
+func repoRelativePattern(pattern string) (string, error) {
+	cwd, err := filepath.Abs(".")
+	if err != nil {
+		return "", err
+	}
+	relativeCwd, err := utils.RepoRelative(utils.AbsolutePath(cwd))
+	if err != nil {
+		return "", err
+	}
+	pattern = filepath.ToSlash(pattern)
+	if relativeCwd == "." {
+		return pattern, nil
+	}
+	return path.Join(filepath.ToSlash(relativeCwd.Relative()), pattern), nil
+}
+
+func trackedFilesMatching(pattern string) ([]utils.RepoRelativePath, error) {
+	trackedFiles, err := utils.GitListTrackedFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	var matching []utils.RepoRelativePath
+	for _, file := range trackedFiles {
+		if !utils.IsPrivateFile(file) && utils.MatchPattern(pattern, file) {
+			matching = append(matching, file)
+		}
+	}
+	return matching, nil
+}
+
+// addFiles adds files and patterns to the file list. If setFormat is true,
+// the format of already tracked files and patterns is changed as well.
+func addFiles(files []utils.RepoRelativePath, patterns []string, format utils.Format, setFormat bool) error {
 	fileList, err := utils.LoadFileList()
 	if err != nil {
 		return err
 	}
 
+	var ignorePatterns []string
+
 	for _, file := range files {
-		if !hasFile(fileList, file) {
+		if !fileList.Covers(file) {
 			fileList.Files = append(fileList.Files, utils.SecureFile{
-				Path: file,
+				Path:   file,
+				Format: format,
 			})
-			err = utils.GitAddIgnorePattern(file.Relative())
+			ignorePatterns = append(ignorePatterns, file.Relative())
+			continue
+		}
+		entry, found := fileList.FindFile(file)
+		if !found {
+			entry = fileList.NewFile(file)
+		}
+		if setFormat && entry.Format != format {
+			err = fileList.UpdateFile(withFormat(entry, format))
 			if err != nil {
 				return err
 			}
 		}
 	}
 
+	for _, pattern := range patterns {
+		if existing := findPattern(&fileList, pattern); existing != nil {
+			if setFormat {
+				existing.Format = format
+				for i := range existing.Files {
+					existing.Files[i] = withFormat(existing.Files[i], format)
+				}
+			}
+			continue
+		}
+		fileList.Patterns = append(fileList.Patterns, utils.SecurePattern{
+			Pattern: pattern,
+			Format:  format,
+		})
+		ignorePatterns = append(ignorePatterns, pattern)
+	}
+
 	err = utils.StoreFileList(fileList)
 	if err != nil {
 		return err
 	}
 
+	for _, pattern := range ignorePatterns {
+		err = addIgnorePattern(pattern)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(patterns) != 0 {
+		err = moveIgnoreNegationLast()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func hasPattern(fileList utils.FileList, pattern string) bool {
+	return findPattern(&fileList, pattern) != nil
+}
+
+func findPattern(fileList *utils.FileList, pattern string) *utils.SecurePattern {
+	for i := range fileList.Patterns {
+		if fileList.Patterns[i].Pattern == pattern {
+			return &fileList.Patterns[i]
+		}
+	}
 	return nil
 }
 
-func hasFile(fileList utils.FileList, file utils.RepoRelativePath) bool {
-	for _, fileEntry := range fileList.Files {
-		if fileEntry.Path == file {
-			return true
+// withFormat changes the format of a file entry. Clearing the recipients
+// hash makes the next hide encrypt the file in the new format.
+func withFormat(file utils.SecureFile, format utils.Format) utils.SecureFile {
+	if file.Format != format {
+		file.Format = format
+		file.RecipientsHash = ""
+	}
+	return file
+}
+
+// addIgnorePattern makes git ignore files matching the pattern.
+// In the blob layout, the pattern is added to the local exclude file
+// instead of .gitignore, to keep paths private.
+func addIgnorePattern(pattern string) error {
+	settings, err := utils.LoadSettings()
+	if err != nil {
+		return err
+	}
+	if settings.Layout == utils.BlobLayout {
+		return utils.GitAddLocalIgnorePattern(pattern)
+	}
+	return utils.GitAddIgnorePattern(pattern)
+}
+
+// removeIgnorePattern removes a pattern added by addIgnorePattern.
+func removeIgnorePattern(pattern string) error {
+	settings, err := utils.LoadSettings()
+	if err != nil {
+		return err
+	}
+	if settings.Layout == utils.BlobLayout {
+		return utils.GitRemoveLocalIgnorePattern(pattern)
+	}
+	return utils.GitRemoveIgnorePattern(pattern)
+}
+
+// moveIgnoreNegationLast makes sure private files matched by
+// ignored patterns are re-included.
+func moveIgnoreNegationLast() error {
+	settings, err := utils.LoadSettings()
+	if err != nil {
+		return err
+	}
+
+	negation, err := privateFilesIgnoreNegation(settings.Layout)
+	if err != nil || negation == "" {
+		return err
+	}
+
+	err = utils.GitRemoveIgnorePattern(negation)
+	if err != nil {
+		return err
+	}
+	return utils.GitAddIgnorePattern(negation)
+}
+
+// privateFilesIgnoreNegation returns the .gitignore pattern
+// that makes sure private files are not ignored in the given layout.
+// In the blob layout, ignore patterns are not in .gitignore.
+func privateFilesIgnoreNegation(layout utils.Layout) (string, error) {
+	switch layout {
+	case utils.BlobLayout:
+		return "", nil
+	case utils.MirrorLayout:
+		storeDir, err := layout.StoreDir()
+		if err != nil {
+			return "", err
 		}
+		return fmt.Sprintf("!/%s/**", filepath.ToSlash(storeDir.Relative())), nil
+	default:
+		return fmt.Sprintf("!*%s", utils.PrivateExtension), nil
 	}
-	return false
 }
@@ -0,0 +1,213 @@
+package commands
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"filippo.io/age/armor"
+
+	"github.com/erkkah/git-private/utils"
+)
+
+// Cat writes the decrypted contents of a hidden file to stdout, from the
+// work tree or from a given revision, without writing plain text to disk.
+func Cat(args []string, usage func()) error {
+	var config struct {
+		KeyFromFile string
+		Revision    string
+	}
+
+	flags := flag.NewFlagSet("cat <file> [-rev REV]", flag.ExitOnError)
+	flags.StringVar(&config.KeyFromFile, "keyfile", "", "Load private key from `file`")
+	flags.StringVar(&config.Revision, "rev", "", "Read the private file of revision `REV`")
+	flags.Usage = usage
+	flags.Parse(args)
+
+	file, err := fileArgument(flags)
+	if err != nil {
+		return err
+	}
+
+	err = utils.EnsureInitialized()
+	if err != nil {
+		return err
+	}
+
+	identity, err := loadPrivateKey(config.KeyFromFile)
+	if err != nil {
+		return err
+	}
+
+	err = unlockFileList(identity)
+	if err != nil {
+		return err
+	}
+
+	path, err := repoRelativeArgument(file)
+	if err != nil {
+		return err
+	}
+
+	var entry utils.SecureFile
+	var encrypted io.ReadCloser
+	if config.Revision != "" {
+		entry, encrypted, err = openRevisionPrivateFile(config.Revision, path)
+	} else {
+		entry, encrypted, err = openPrivateFile(path)
+	}
+	if err != nil {
+		return err
+	}
+	defer encrypted.Close()
+
+	identities, err := fileIdentities(identity)
+	if err != nil {
+		return err
+	}
+
+	// Structured files are decrypted value by value, and whole
+	// files are streamed
+	buffered := bufio.NewReader(encrypted)
+	start, _ := buffered.Peek(len(armor.Header))
+	if !isEncrypted(start) {
+		data, err := io.ReadAll(buffered)
+		if err != nil {
+			return err
+		}
+		decrypted, err := decryptPrivateData(entry, data, identities...)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt %q: %w", path, err)
+		}
+		_, err = os.Stdout.Write(decrypted)
+		return err
+	}
+
+	decryptedReader, err := decryptReader(buffered, identities...)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt %q: %w", path, err)
+	}
+	_, err = io.Copy(os.Stdout, decryptedReader)
+	return err
+}
+
+// openPrivateFile opens the private file of a tracked file in the work tree.
+func openPrivateFile(path utils.RepoRelativePath) (utils.SecureFile, io.ReadCloser, error) {
+	fileList, err := utils.LoadFileList()
+	if err != nil {
+		return utils.SecureFile{}, nil, err
+	}
+	entry, found := fileList.FindFile(path)
+	if !found {
+		return entry, nil, fmt.Errorf("%q is not tracked", path)
+	}
+
+	privatePath, err := utils.PrivatePath(entry)
+	if err != nil {
+		return entry, nil, err
+	}
+	fullPath, err := utils.RepoAbsolute(privatePath)
+	if err != nil {
+		return entry, nil, err
+	}
+	reader, err := fullPath.Open()
+	if os.IsNotExist(err) {
+		return entry, nil, fmt.Errorf("%q is not hidden", path)
+	}
+	if err != nil {
+		return entry, nil, err
+	}
+	return entry, reader, nil
+}
+
+// openRevisionPrivateFile opens the private file of a file, as tracked
+// in the given revision.
+func openRevisionPrivateFile(revision string, path utils.RepoRelativePath) (utils.SecureFile, io.ReadCloser, error) {
+	fileList, layout, err := revisionFileList(revision)
+	if err != nil {
+		return utils.SecureFile{}, nil, err
+	}
+	entry, found := fileList.FindFile(path)
+	if !found {
+		return entry, nil, fmt.Errorf("%q is not hidden in revision %q", path, revision)
+	}
+
+	privatePath, err := layout.PrivatePath(entry)
+	if err != nil {
+		return entry, nil, err
+	}
+	reader, exists, err := utils.GitOpenBlob(revision + ":" + filepath.ToSlash(privatePath.Relative()))
+	if err != nil {
+		return entry, nil, err
+	}
+	if !exists {
+		return entry, nil, fmt.Errorf("no private file of %q in revision %q", path, revision)
+	}
+	return entry, reader, nil
+}
+
+// revisionFileList loads the file list and the layout of the given revision.
+func revisionFileList(revision string) (utils.FileList, utils.Layout, error) {
+	var settings utils.Settings
+	data, exists, err := readRevisionStateFile(revision, utils.SettingsFile)
+	if err != nil {
+		return utils.FileList{}, "", err
+	}
+	if exists {
+		settings, err = utils.ParseSettings(data)
+		if err != nil {
+			return utils.FileList{}, "", fmt.Errorf("invalid settings in revision %q: %w", revision, err)
+		}
+	}
+
+	var fileList utils.FileList
+	if settings.Layout == utils.BlobLayout {
+		data, exists, err = readRevisionStateFile(revision, utils.IndexFile)
+		if err == nil && exists {
+			fileList, err = utils.DecryptFileIndex(data)
+		}
+	} else {
+		data, exists, err = readRevisionStateFile(revision, utils.PathsFile)
+		if err == nil && exists {
+			fileList, err = utils.ParseFileList(data)
+		}
+	}
+	if err != nil {
+		return utils.FileList{}, "", fmt.Errorf("failed to read file list of revision %q: %w", revision, err)
+	}
+	if !exists {
+		return utils.FileList{}, "", fmt.Errorf("no file list in revision %q", revision)
+	}
+
+	return fileList, settings.Layout, nil
+}
+
+// readRevisionStateFile reads a state file as stored in the given revision.
+func readRevisionStateFile(revision string, stateFile func() (utils.AbsolutePath, error)) ([]byte, bool, error) {
+	absolute, err := stateFile()
+	if err != nil {
+		return nil, false, err
+	}
+	relative, err := utils.RepoRelative(absolute)
+	if err != nil {
+		return nil, false, err
+	}
+	return utils.GitReadBlob(revision + ":" + filepath.ToSlash(relative.Relative()))
+}
+
+// fileArgument returns the single file argument of a command,
+// parsing flags given after the file too.
+func fileArgument(flags *flag.FlagSet) (string, error) {
+	if flags.NArg() == 0 {
+		return "", fmt.Errorf("expected <file> argument")
+	}
+	file := flags.Arg(0)
+	flags.Parse(flags.Args()[1:])
+	if flags.NArg() != 0 {
+		return "", fmt.Errorf("expected one <file> argument")
+	}
+	return file, nil
+}
@@ -23,12 +23,22 @@ func Clean(args []string, usage func()) error {
 		return err
 	}
 
+	err = unlockFileList(nil)
+	if err != nil {
+		return err
+	}
+
 	fileList, err := utils.LoadFileList()
 	if err != nil {
 		return err
 	}
 
-	err = cleanFiles(fileList.Files, config.Force)
+	hasher, err := loadFileHasher(fileList, nil)
+	if err != nil && !config.Force {
+		return err
+	}
+
+	err = cleanFiles(hasher, fileList.AllFiles(), config.Force)
 	if err != nil {
 		return err
 	}
@@ -36,11 +46,11 @@ func Clean(args []string, usage func()) error {
 	return nil
 }
 
-func cleanFiles(filesToClean []utils.SecureFile, force bool) error {
+func cleanFiles(hasher utils.FileHasher, filesToClean []utils.SecureFile, force bool) error {
 	for _, file := range filesToClean {
 
 		if !force {
-			status, err := getFileStatus(file)
+			status, err := getFileStatus(hasher, file)
 			if err != nil {
 				return err
 			}
@@ -49,7 +59,7 @@ func cleanFiles(filesToClean []utils.SecureFile, force bool) error {
 				return fmt.Errorf("will not remove file %q with missing private file, use 'force' flag to override", file.Path)
 			}
 
-			if status == hiddenModified {
+			if status == hiddenModified || status == hiddenConflict {
 				return fmt.Errorf("will not remove out of sync file %q, use 'force' flag to override", file.Path)
 			}
 		}
@@ -0,0 +1,51 @@
+package commands
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/erkkah/git-private/utils"
+)
+
+// Compression turns compression of files hidden from then on
+// on or off. Existing private files are left as is.
+func Compression(args []string, usage func()) error {
+	flags := flag.NewFlagSet("compression <enable|disable>", flag.ExitOnError)
+	flags.Usage = usage
+
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		flags.Parse(args[1:])
+	} else {
+		return fmt.Errorf("no compression command specified, expected <enable|disable>")
+	}
+
+	err := utils.EnsureInitialized()
+	if err != nil {
+		return err
+	}
+
+	settings, err := utils.LoadSettings()
+	if err != nil {
+		return err
+	}
+
+	cmd := args[0]
+
+	switch cmd {
+	case "enable":
+		if settings.Compress {
+			return fmt.Errorf("compression already enabled")
+		}
+		settings.Compress = true
+	case "disable":
+		if !settings.Compress {
+			return fmt.Errorf("compression not enabled")
+		}
+		settings.Compress = false
+	default:
+		return fmt.Errorf("unknown compression command %q", cmd)
+	}
+
+	return utils.StoreSettings(settings)
+}
@@ -0,0 +1,103 @@
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+
+	"filippo.io/age/armor"
+
+	"github.com/erkkah/git-private/utils"
+)
+
+// Convert rewrites all private files in armored or binary format,
+// and makes that the format of files hidden from then on.
+// Files are re-encoded, not re-encrypted, so no private key is needed.
+func Convert(args []string, usage func()) error {
+	var config struct {
+		Armor  bool
+		Binary bool
+	}
+
+	flags := flag.NewFlagSet("convert <-armor | -binary>", flag.ExitOnError)
+	flags.BoolVar(&config.Armor, "armor", false, "Store private files in armored text format")
+	flags.BoolVar(&config.Binary, "binary", false, "Store private files in binary format")
+	flags.Usage = usage
+	flags.Parse(args)
+
+	if config.Armor == config.Binary {
+		return fmt.Errorf("expected one of -armor or -binary")
+	}
+
+	err := utils.EnsureInitialized()
+	if err != nil {
+		return err
+	}
+
+	err = unlockFileList(nil)
+	if err != nil {
+		return err
+	}
+
+	settings, err := utils.LoadSettings()
+	if err != nil {
+		return err
+	}
+	settings.Armor = config.Armor
+	err = utils.StoreSettings(settings)
+	if err != nil {
+		return err
+	}
+
+	return rewritePrivateFiles(func(file utils.SecureFile, encrypted []byte) ([]byte, error) {
+		// Structured files are text already
+		if !isEncrypted(encrypted) || isArmored(encrypted) == config.Armor {
+			return encrypted, nil
+		}
+		if config.Armor {
+			return armorData(encrypted)
+		}
+		decoded, err := io.ReadAll(armor.NewReader(bytes.NewReader(encrypted)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %q: %w", file.Path, err)
+		}
+		return decoded, nil
+	}, "")
+}
+
+// isArmored checks if encrypted data is in the armored text format.
+func isArmored(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(armor.Header))
+}
+
+// isEncrypted checks if data looks like an age file, binary or armored.
+func isEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, ageMagic) || isArmored(data)
+}
+
+func armorData(encrypted []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	armorWriter := armor.NewWriter(&buf)
+	_, err := armorWriter.Write(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	err = armorWriter.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// dearmorReader returns a reader of the binary age file,
+// for both armored and binary input.
+func dearmorReader(encrypted io.Reader) io.Reader {
+	buffered := bufio.NewReader(encrypted)
+	start, _ := buffered.Peek(len(armor.Header))
+	if isArmored(start) {
+		return armor.NewReader(buffered)
+	}
+	return buffered
+}
@@ -0,0 +1,87 @@
+package commands
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/erkkah/git-private/utils"
+)
+
+// DiffInstall registers the textconv diff driver in the local git config,
+// and assigns it to private files in .gitattributes.
+func DiffInstall(args []string, usage func()) error {
+	flags := flag.NewFlagSet("diff-install", flag.ExitOnError)
+	flags.Usage = usage
+	flags.Parse(args)
+
+	err := utils.EnsureInitialized()
+	if err != nil {
+		return err
+	}
+
+	err = utils.GitSetConfig(fmt.Sprintf("diff.%s.textconv", filterName), fmt.Sprintf("%s textconv", utils.ToolName))
+	if err != nil {
+		return err
+	}
+
+	patterns, err := utils.PrivateFilePatterns()
+	if err != nil {
+		return err
+	}
+
+	for _, pattern := range patterns {
+		err = utils.GitAddAttributes(pattern, "diff="+filterName)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Textconv prints the decrypted contents of the given file to stdout.
+// If the file cannot be decrypted, a placeholder is printed instead.
+func Textconv(args []string, _ func()) error {
+	if len(args) != 1 {
+		return fmt.Errorf("expected one file argument")
+	}
+
+	encrypted, err := os.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+
+	if !isEncrypted(encrypted) {
+		_, err = os.Stdout.Write(encrypted)
+		return err
+	}
+
+	// The placeholder includes a hash of the encrypted data to
+	// make changes visible even without access.
+	hash := sha256.Sum256(encrypted)
+	placeholder := fmt.Sprintf("<%s: encrypted content %x", utils.ToolName, hash[:8])
+
+	identity, err := loadPrivateKey("")
+	if err != nil {
+		fmt.Printf("%s, no private key available>\n", placeholder)
+		return nil
+	}
+
+	identities, err := fileIdentities(identity)
+	if err != nil {
+		fmt.Printf("%s, no access>\n", placeholder)
+		return nil
+	}
+
+	decrypted, err := decryptData(bytes.NewReader(encrypted), identities...)
+	if err != nil {
+		fmt.Printf("%s, no access>\n", placeholder)
+		return nil
+	}
+
+	_, err = os.Stdout.Write(decrypted)
+	return err
+}
@@ -0,0 +1,146 @@
+package commands
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+)
+
+// Edit decrypts a hidden file to a temporary file, opens it in the
+// configured editor, and hides the result if it was changed.
+// The revealed file is updated too, if it is in sync.
+func Edit(args []string, usage func()) error {
+	var config struct {
+		KeyFromFile string
+	}
+
+	flags := flag.NewFlagSet("edit <file>", flag.ExitOnError)
+	flags.StringVar(&config.KeyFromFile, "keyfile", "", "Load private key from `file`")
+	flags.Usage = usage
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expected <file> argument")
+	}
+
+	hidden, err := loadHiddenFile(flags.Arg(0), config.KeyFromFile)
+	if err != nil {
+		return err
+	}
+
+	revealed, err := ensureNotModified(hidden)
+	if err != nil {
+		return err
+	}
+
+	tempDir, err := os.MkdirTemp(editDir(), "git-private-")
+	if err != nil {
+		return err
+	}
+	// Editors might leave swap and backup files next to the edited file
+	defer os.RemoveAll(tempDir)
+
+	tempFile := filepath.Join(tempDir, filepath.Base(string(hidden.entry.Path)))
+	err = os.WriteFile(tempFile, hidden.plain, 0600)
+	if err != nil {
+		return err
+	}
+	defer scrubFile(tempFile)
+
+	err = runEditor(tempFile)
+	if err != nil {
+		return err
+	}
+
+	edited, err := os.ReadFile(tempFile)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(edited, hidden.plain) {
+		fmt.Printf("%q not changed\n", hidden.entry.Path)
+		return nil
+	}
+
+	return rehide(hidden, edited, revealed)
+}
+
+// editDir returns the directory for decrypted temporary files,
+// preferring memory backed storage.
+func editDir() string {
+	const shm = "/dev/shm"
+	if info, err := os.Stat(shm); err == nil && info.IsDir() {
+		return shm
+	}
+	return os.TempDir()
+}
+
+// runEditor opens the file in the editor given by GIT_EDITOR, VISUAL or
+// EDITOR, like git does.
+func runEditor(file string) error {
+	editor := "vi"
+	for _, variable := range []string{"GIT_EDITOR", "VISUAL", "EDITOR"} {
+		if value := os.Getenv(variable); value != "" {
+			editor = value
+			break
+		}
+	}
+
+	// Run through the shell, to allow editor arguments
+	cmd := exec.Command("sh", "-c", editor+` "$@"`, editor, file)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	// Catch signals while the editor runs, so that the decrypted
+	// file is removed. Interrupts reach the editor from the terminal,
+	// terminations are passed on.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	err := cmd.Start()
+	if err != nil {
+		return fmt.Errorf("editor %q failed: %w", editor, err)
+	}
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	interrupted := false
+	for {
+		select {
+		case sig := <-signals:
+			interrupted = true
+			if sig == syscall.SIGTERM {
+				cmd.Process.Signal(sig)
+			}
+		case err := <-exited:
+			if interrupted {
+				return fmt.Errorf("interrupted, changes discarded")
+			}
+			if err != nil {
+				return fmt.Errorf("editor %q failed: %w", editor, err)
+			}
+			return nil
+		}
+	}
+}
+
+// scrubFile overwrites a file with zeros before removing it.
+func scrubFile(file string) {
+	info, err := os.Stat(file)
+	if err == nil {
+		if writer, err := os.OpenFile(file, os.O_WRONLY, 0); err == nil {
+			writer.Write(make([]byte, info.Size()))
+			writer.Sync()
+			writer.Close()
+		}
+	}
+	os.Remove(file)
+}
@@ -0,0 +1,320 @@
+package commands
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+
+	"github.com/erkkah/git-private/utils"
+)
+
+// Envelope enables or disables envelope mode, where files are encrypted
+// to a shared data key instead of directly to all keys.
+// Existing private files are re-encrypted from their encrypted versions.
+func Envelope(args []string, usage func()) error {
+	var config struct {
+		KeyFromFile string
+	}
+
+	flags := flag.NewFlagSet("envelope <enable|disable>", flag.ExitOnError)
+	flags.StringVar(&config.KeyFromFile, "keyfile", "", "Load private key from `file`")
+	flags.Usage = usage
+
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		flags.Parse(args[1:])
+	} else {
+		return fmt.Errorf("no envelope command specified, expected <enable|disable>")
+	}
+
+	err := utils.EnsureInitialized()
+	if err != nil {
+		return err
+	}
+
+	settings, err := utils.LoadSettings()
+	if err != nil {
+		return err
+	}
+
+	identity, err := loadPrivateKey(config.KeyFromFile)
+	if err != nil {
+		return err
+	}
+
+	err = unlockFileList(identity)
+	if err != nil {
+		return err
+	}
+
+	cmd := args[0]
+
+	switch cmd {
+	case "enable":
+		if settings.Envelope {
+			return fmt.Errorf("envelope mode already enabled")
+		}
+		return enableEnvelope(identity, settings)
+	case "disable":
+		if !settings.Envelope {
+			return fmt.Errorf("envelope mode not enabled")
+		}
+		return disableEnvelope(identity, settings)
+	default:
+		return fmt.Errorf("unknown envelope command %q", cmd)
+	}
+}
+
+func enableEnvelope(identity age.Identity, settings utils.Settings) error {
+	identities, err := fileIdentities(identity)
+	if err != nil {
+		return err
+	}
+
+	dataKey, err := age.GenerateX25519Identity()
+	if err != nil {
+		return err
+	}
+
+	err = utils.StoreDataKey(identity, dataKey)
+	if err != nil {
+		return err
+	}
+
+	err = reEncryptPrivateFiles(identities, []age.Recipient{dataKey.Recipient()}, utils.DataKeyFingerprint(dataKey))
+	if err != nil {
+		return err
+	}
+
+	settings.Envelope = true
+	return utils.StoreSettings(settings)
+}
+
+func disableEnvelope(identity age.Identity, settings utils.Settings) error {
+	identities, err := fileIdentities(identity)
+	if err != nil {
+		return err
+	}
+
+	settings.Envelope = false
+	err = utils.StoreSettings(settings)
+	if err != nil {
+		return err
+	}
+
+	recipients, recipientsHash, err := fileRecipients(identity)
+	if err != nil {
+		return err
+	}
+
+	err = reEncryptPrivateFiles(identities, recipients, recipientsHash)
+	if err != nil {
+		return err
+	}
+
+	dataKeyFile, err := utils.DataKeyFile()
+	if err != nil {
+		return err
+	}
+	return os.Remove(dataKeyFile.Absolute())
+}
+
+// fileRecipients returns the recipients to encrypt files to, and
+// the fingerprint identifying them. In envelope mode, that is the data key,
+// otherwise all keys in the key list.
+func fileRecipients(identity age.Identity) ([]age.Recipient, string, error) {
+	settings, err := utils.LoadSettings()
+	if err != nil {
+		return nil, "", err
+	}
+
+	if settings.Envelope {
+		// The data key is readable by read-only keys too, but only keys
+		// with write access can read the key list, and encrypt
+		_, err := utils.LoadKeyList(identity)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to load keys, cannot encrypt: %w", err)
+		}
+		dataKey, found, err := utils.LoadDataKey(identity)
+		if err != nil {
+			return nil, "", err
+		}
+		if !found {
+			return nil, "", fmt.Errorf("envelope mode enabled, but there is no data key")
+		}
+		return []age.Recipient{dataKey.Recipient()}, utils.DataKeyFingerprint(dataKey), nil
+	}
+
+	recipients, err := utils.GetRecipients(identity)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load keys, cannot encrypt: %w", err)
+	}
+	if len(recipients) == 0 {
+		return nil, "", fmt.Errorf("no keys added, cannot encrypt")
+	}
+	recipientsHash, err := utils.GetRecipientsFingerprint(identity)
+	if err != nil {
+		return nil, "", err
+	}
+	return recipients, recipientsHash, nil
+}
+
+// fileIdentities returns the identities that can decrypt files,
+// the given identity and the data keys, if any.
+// Files encrypted before switching modes are still readable.
+func fileIdentities(identity age.Identity) ([]age.Identity, error) {
+	identities := []age.Identity{identity}
+
+	dataKeys, _, err := utils.LoadDataKeys(identity)
+	if err != nil {
+		return nil, err
+	}
+	for _, dataKey := range dataKeys {
+		identities = append(identities, dataKey)
+	}
+
+	return identities, nil
+}
+
+// rewrapDataKey encrypts the data key, and any previous data keys,
+// to the current key list.
+func rewrapDataKey(identity age.Identity) error {
+	dataKeys, found, err := utils.LoadDataKeys(identity)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("envelope mode enabled, but there is no data key")
+	}
+	return utils.StoreDataKey(identity, dataKeys[0], dataKeys[1:]...)
+}
+
+// rotateDataKey replaces the data key, and re-encrypts all private files
+// to the new key. The old data key is loaded using the given identities.
+// Until all files are re-encrypted, the old data keys are stored along with
+// the new one, so that files stay readable if re-encrypting fails.
+func rotateDataKey(identity age.Identity, identities []age.Identity) error {
+	previous, _, err := utils.LoadDataKeys(identity)
+	if err != nil {
+		return err
+	}
+
+	dataKey, err := age.GenerateX25519Identity()
+	if err != nil {
+		return err
+	}
+
+	err = utils.StoreDataKey(identity, dataKey, previous...)
+	if err != nil {
+		return err
+	}
+
+	err = reEncryptPrivateFiles(identities, []age.Recipient{dataKey.Recipient()}, utils.DataKeyFingerprint(dataKey))
+	if err != nil {
+		return fmt.Errorf("failed to re-encrypt files to the new data key, use 'reencrypt' to retry: %w", err)
+	}
+
+	return utils.StoreDataKey(identity, dataKey)
+}
+
+// dropPreviousDataKeys stores the data key without the previous data keys,
+// once no files are encrypted to them.
+func dropPreviousDataKeys(identity age.Identity) error {
+	dataKey, found, err := utils.LoadDataKey(identity)
+	if err != nil || !found {
+		return err
+	}
+	return utils.StoreDataKey(identity, dataKey)
+}
+
+// reEncryptPrivateFiles decrypts all private files, and encrypts them again
+// to the given recipients, without reading the revealed files.
+func reEncryptPrivateFiles(identities []age.Identity, recipients []age.Recipient, recipientsHash string) error {
+	return rewritePrivateFiles(func(file utils.SecureFile, encrypted []byte) ([]byte, error) {
+		decrypted, err := decryptPrivateData(file, encrypted, identities...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt %q: %w", file.Path, err)
+		}
+		return encryptPrivateData(file, decrypted, recipients, nil, nil)
+	}, recipientsHash)
+}
+
+// rewritePrivateFiles replaces the contents of all private files, as
+// returned by the rewrite function, keeping their hashes in the file list
+// and sync state up to date. The recipients hash of rewritten files is
+// set to the given hash, unless empty.
+func rewritePrivateFiles(rewrite func(file utils.SecureFile, encrypted []byte) ([]byte, error), recipientsHash string) error {
+	fileList, err := utils.LoadFileList()
+	if err != nil {
+		return err
+	}
+	syncState, err := utils.LoadSyncState()
+	if err != nil {
+		return err
+	}
+
+	for _, file := range fileList.AllFiles() {
+		privatePath, err := utils.PrivatePath(file)
+		if err != nil {
+			return err
+		}
+		privateFile, err := utils.RepoAbsolute(privatePath)
+		if err != nil {
+			return err
+		}
+		exists, err := utils.Exists(privateFile)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+
+		previousHash, err := utils.GetFileHash(privatePath)
+		if err != nil {
+			return err
+		}
+
+		encrypted, err := os.ReadFile(privateFile.Absolute())
+		if err != nil {
+			return err
+		}
+		rewritten, err := rewrite(file, encrypted)
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(privateFile.Absolute(), rewritten, 0600)
+		if err != nil {
+			return err
+		}
+
+		privateHash, err := utils.GetFileHash(privatePath)
+		if err != nil {
+			return err
+		}
+
+		if file.PrivateHash == previousHash {
+			file.PrivateHash = privateHash
+			if recipientsHash != "" {
+				file.RecipientsHash = recipientsHash
+			}
+			err = fileList.UpdateFile(file)
+			if err != nil {
+				return err
+			}
+		}
+
+		// The revealed file has the same relation to the new private file
+		if synced, found := syncState.FindFile(file.Path); found && synced.PrivateHash == previousHash {
+			synced.PrivateHash = privateHash
+			err = utils.RecordSynced(synced)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return utils.StoreFileList(fileList)
+}
@@ -0,0 +1,191 @@
+package commands
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"filippo.io/age"
+
+	"github.com/erkkah/git-private/utils"
+)
+
+const filterName = "gitprivate"
+
+var ageMagic = []byte("age-encryption.org/")
+
+// FilterInstall registers the clean and smudge filters in the local git config,
+// and marks the given patterns for filtering in .gitattributes.
+func FilterInstall(args []string, usage func()) error {
+	flags := flag.NewFlagSet("filter-install [pattern...]", flag.ExitOnError)
+	flags.Usage = usage
+	flags.Parse(args)
+
+	err := utils.EnsureInitialized()
+	if err != nil {
+		return err
+	}
+
+	settings, err := utils.LoadSettings()
+	if err != nil {
+		return err
+	}
+	if settings.Layout == utils.BlobLayout {
+		// Filtered paths would be listed in .gitattributes
+		return fmt.Errorf("filters cannot be used with the blob layout")
+	}
+
+	filterConfig := map[string]string{
+		"clean":    fmt.Sprintf("%s filter-clean %%f", utils.ToolName),
+		"smudge":   fmt.Sprintf("%s filter-smudge %%f", utils.ToolName),
+		"required": "true",
+	}
+
+	for key, value := range filterConfig {
+		err = utils.GitSetConfig(fmt.Sprintf("filter.%s.%s", filterName, key), value)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, pattern := range flags.Args() {
+		err = utils.GitAddAttributes(pattern, "filter="+filterName)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// FilterClean encrypts plain text from stdin to stdout.
+// If the file already has an encrypted version in the index with the same
+// contents, that version is used as is, to keep 'git status' clean.
+// Versions encrypted to other keys than the current ones are replaced.
+func FilterClean(args []string, _ func()) error {
+	plain, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+
+	if isEncrypted(plain) {
+		// Not revealed, pass on as is
+		_, err = os.Stdout.Write(plain)
+		return err
+	}
+
+	identity, err := loadPrivateKey("")
+	if err != nil {
+		return err
+	}
+
+	identities, err := fileIdentities(identity)
+	if err != nil {
+		return err
+	}
+
+	recipients, recipientsHash, err := fileRecipients(identity)
+	if err != nil {
+		return err
+	}
+
+	if len(args) > 0 {
+		indexed, exists, err := utils.GitReadBlob(":" + args[0])
+		if err != nil {
+			return err
+		}
+		if exists && isFilteredForRecipients(utils.RepoRelativePath(args[0]), indexed, recipientsHash) {
+			decrypted, err := decryptData(bytes.NewReader(indexed), identities...)
+			if err == nil && bytes.Equal(decrypted, plain) {
+				_, err = os.Stdout.Write(indexed)
+				return err
+			}
+		}
+	}
+
+	encrypted, err := encryptData(bytes.NewReader(plain), recipients)
+	if err != nil {
+		return err
+	}
+
+	if len(args) > 0 {
+		err = recordFiltered(utils.RepoRelativePath(args[0]), encrypted, recipientsHash)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = os.Stdout.Write(encrypted)
+	return err
+}
+
+// isFilteredForRecipients checks if the encrypted version of a filtered
+// file was recorded as encrypted to the given recipients, when last
+// cleaned or smudged in this work tree.
+func isFilteredForRecipients(file utils.RepoRelativePath, encrypted []byte, recipientsHash string) bool {
+	syncState, err := utils.LoadSyncState()
+	if err != nil {
+		return false
+	}
+	synced, found := syncState.FindFile(file)
+	return found && synced.PrivateHash == utils.GetDataHash(encrypted) && synced.RecipientsHash == recipientsHash
+}
+
+// recordFiltered records the recipients of the encrypted version of a filtered file.
+func recordFiltered(file utils.RepoRelativePath, encrypted []byte, recipientsHash string) error {
+	return utils.RecordSynced(utils.SecureFile{
+		Path:           file,
+		PrivateHash:    utils.GetDataHash(encrypted),
+		RecipientsHash: recipientsHash,
+	})
+}
+
+// FilterSmudge decrypts encrypted data from stdin to stdout.
+// Data that cannot be decrypted is passed on as is, so that
+// checkouts work for users without access.
+func FilterSmudge(args []string, _ func()) error {
+	encrypted, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+
+	output := encrypted
+
+	if isEncrypted(encrypted) {
+		var decrypted []byte
+		var identity age.Identity
+		var identities []age.Identity
+
+		identity, err = loadPrivateKey("")
+		if err == nil {
+			identities, err = fileIdentities(identity)
+		}
+		if err == nil {
+			decrypted, err = decryptData(bytes.NewReader(encrypted), identities...)
+		}
+		if err == nil {
+			output = decrypted
+
+			// Checked out versions are encrypted to the checked out keys
+			if len(args) > 0 {
+				if _, recipientsHash, err := fileRecipients(identity); err == nil {
+					err = recordFiltered(utils.RepoRelativePath(args[0]), encrypted, recipientsHash)
+					if err != nil {
+						return err
+					}
+				}
+			}
+		} else {
+			fileName := "file"
+			if len(args) > 0 {
+				fileName = fmt.Sprintf("%q", args[0])
+			}
+			fmt.Fprintf(os.Stderr, "%s: cannot decrypt %s, leaving it encrypted: %v\n", utils.ToolName, fileName, err)
+		}
+	}
+
+	_, err = os.Stdout.Write(output)
+	return err
+}
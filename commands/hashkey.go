@@ -0,0 +1,254 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"filippo.io/age"
+
+	"github.com/erkkah/git-private/utils"
+)
+
+// loadFileHasher returns the hasher for plain text hashes in the file list.
+// The hash key is loaded from the local copy if possible, otherwise using
+// the given identity, or the private key from the environment if nil.
+func loadFileHasher(fileList utils.FileList, identity age.Identity) (utils.FileHasher, error) {
+	hasher, err := utils.NewFileHasher(fileList)
+	if !errors.Is(err, utils.ErrNoHashKey) {
+		return hasher, err
+	}
+
+	err = unlockHashKey(identity)
+	if err != nil {
+		return utils.FileHasher{}, err
+	}
+
+	return utils.NewFileHasher(fileList)
+}
+
+// unlockFileList makes sure the file list can be loaded. In the blob layout,
+// the file list is encrypted using the hash key, which is loaded like
+// in loadFileHasher, unless available locally.
+func unlockFileList(identity age.Identity) error {
+	settings, err := utils.LoadSettings()
+	if err != nil {
+		return err
+	}
+	if settings.Layout != utils.BlobLayout {
+		return nil
+	}
+
+	_, found, err := utils.LoadCachedHashKey()
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		hashKeyFile, err := utils.HashKeyFile()
+		if err != nil {
+			return err
+		}
+		exists, err := utils.Exists(hashKeyFile)
+		if err != nil || !exists {
+			// The index is created together with the hash key
+			return err
+		}
+
+		err = unlockHashKey(identity)
+		if err != nil {
+			return err
+		}
+	}
+
+	return excludeTrackedFiles()
+}
+
+// excludeTrackedFiles ignores all tracked files and patterns in this clone.
+// In the blob layout, they are not listed in .gitignore, so a new clone
+// would otherwise not ignore files matching tracked patterns.
+func excludeTrackedFiles() error {
+	fileList, err := utils.LoadFileList()
+	if err != nil {
+		return err
+	}
+
+	var patterns []string
+	for _, file := range fileList.Files {
+		patterns = append(patterns, file.Path.Relative())
+	}
+	for _, pattern := range fileList.Patterns {
+		patterns = append(patterns, pattern.Pattern)
+	}
+	return utils.GitAddLocalIgnorePatterns(patterns)
+}
+
+// unlockHashKey loads the hash key, and keeps a local copy. The given identity
+// is used, or the private key from the environment if nil.
+func unlockHashKey(identity age.Identity) error {
+	var err error
+	if identity == nil {
+		identity, err = loadPrivateKey("")
+		if err != nil {
+			return fmt.Errorf("%w, private key needed: %v", utils.ErrNoHashKey, err)
+		}
+	}
+
+	_, found, err := utils.LoadHashKey(identity)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("file list uses keyed hashes, but there is no hash key")
+	}
+	return nil
+}
+
+// migrateFileList upgrades the file list to keyed hashes,
+// creating the hash key if needed.
+func migrateFileList(identity age.Identity) error {
+	fileList, err := utils.LoadFileList()
+	if err != nil {
+		return err
+	}
+	if fileList.Version >= utils.KeyedHashVersion {
+		return nil
+	}
+
+	key, found, err := utils.LoadHashKey(identity)
+	if err != nil {
+		return err
+	}
+	if !found {
+		key, err = utils.GenerateHashKey()
+		if err != nil {
+			return err
+		}
+		err = utils.StoreHashKey(identity, key)
+		if err != nil {
+			return err
+		}
+	}
+
+	identities, err := fileIdentities(identity)
+	if err != nil {
+		return err
+	}
+
+	return rehashFiles(fileList, identities, utils.NewKeyedFileHasher(key), utils.KeyedHashVersion)
+}
+
+// rewrapHashKey encrypts the hash key, if any, to the current key list.
+func rewrapHashKey(identity age.Identity) error {
+	key, found, err := utils.LoadHashKey(identity)
+	if err != nil || !found {
+		return err
+	}
+	return utils.StoreHashKey(identity, key)
+}
+
+// rotateHashKey replaces the hash key, if any, and updates all hashes.
+func rotateHashKey(identity age.Identity) error {
+	_, found, err := utils.LoadHashKey(identity)
+	if err != nil || !found {
+		return err
+	}
+
+	identities, err := fileIdentities(identity)
+	if err != nil {
+		return err
+	}
+
+	// Load before replacing the key, the file list might be encrypted using it
+	fileList, err := utils.LoadFileList()
+	if err != nil {
+		return err
+	}
+
+	key, err := utils.GenerateHashKey()
+	if err != nil {
+		return err
+	}
+	err = utils.StoreHashKey(identity, key)
+	if err != nil {
+		return err
+	}
+
+	return rehashFiles(fileList, identities, utils.NewKeyedFileHasher(key), utils.KeyedHashVersion)
+}
+
+// rehashFiles updates the plain text hashes of all hidden files in the file list
+// using the given hasher, from the contents of their private files, and stores
+// the file list with the given version.
+// Local sync records that can not be updated are dropped.
+func rehashFiles(fileList utils.FileList, identities []age.Identity, hasher utils.FileHasher, version int) error {
+	syncState, err := utils.LoadSyncState()
+	if err != nil {
+		return err
+	}
+
+	var synced []utils.SecureFile
+
+	for _, file := range fileList.AllFiles() {
+		if file.Hash == "" {
+			continue
+		}
+
+		privatePath, err := utils.PrivatePath(file)
+		if err != nil {
+			return err
+		}
+		privateFile, err := utils.RepoAbsolute(privatePath)
+		if err != nil {
+			return err
+		}
+		exists, err := utils.Exists(privateFile)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			// Cannot be rehashed, will be hidden again
+			file.Hash = ""
+			err = fileList.UpdateFile(file)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		encrypted, err := os.ReadFile(privateFile.Absolute())
+		if err != nil {
+			return err
+		}
+		decrypted, err := decryptPrivateData(file, encrypted, identities...)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt %q: %w", file.Path, err)
+		}
+		privateHash, err := utils.GetFileHash(privatePath)
+		if err != nil {
+			return err
+		}
+
+		hash := hasher.Hash(decrypted)
+
+		if record, found := syncState.FindFile(file.Path); found && record.PrivateHash == privateHash {
+			record.Hash = hash
+			synced = append(synced, record)
+		}
+
+		file.Hash = hash
+		err = fileList.UpdateFile(file)
+		if err != nil {
+			return err
+		}
+	}
+
+	syncState.Files = synced
+	err = utils.StoreSyncState(syncState)
+	if err != nil {
+		return err
+	}
+
+	fileList.Version = version
+	return utils.StoreFileList(fileList)
+}
@@ -7,7 +7,6 @@ import (
 	"io"
 	"os"
 	"path/filepath"
-	"strings"
 
 	"filippo.io/age"
 
@@ -18,11 +17,13 @@ func Hide(args []string, usage func()) error {
 	var config struct {
 		KeyFromFile string
 		Clean       bool
+		Force       bool
 	}
 
 	flags := flag.NewFlagSet("hide [file]", flag.ExitOnError)
 	flags.StringVar(&config.KeyFromFile, "keyfile", "", "Load private key from `file`")
 	flags.BoolVar(&config.Clean, "clean", false, "Remove source files after encryption")
+	flags.BoolVar(&config.Force, "force", false, "Re-encrypt unchanged files, and hide files even if their private files were updated")
 	flags.Usage = usage
 	flags.Parse(args)
 
@@ -31,6 +32,16 @@ func Hide(args []string, usage func()) error {
 		return err
 	}
 
+	identity, err := loadPrivateKey(config.KeyFromFile)
+	if err != nil {
+		return err
+	}
+
+	err = unlockFileList(identity)
+	if err != nil {
+		return err
+	}
+
 	var filesToHide []utils.RepoRelativePath
 
 	fileArgs := flags.Args()
@@ -52,46 +63,103 @@ func Hide(args []string, usage func()) error {
 			return err
 		}
 
-		for _, file := range fileList.Files {
+		files, err := utils.ExpandFileList(fileList)
+		if err != nil {
+			return err
+		}
+
+		// Forget about removed files that matched patterns
+		if fileList.RetainPatternFiles(files) {
+			err = utils.StoreFileList(fileList)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, file := range files {
 			filesToHide = append(filesToHide, file.Path)
 		}
 	}
 
-	identity, err := loadPrivateKey(config.KeyFromFile)
+	err = hideFiles(identity, filesToHide, config.Clean, config.Force)
 	if err != nil {
 		return err
 	}
 
-	err = hideFiles(identity, filesToHide, config.Clean)
+	return nil
+}
+
+// hideFiles encrypts the given files. Files with updated private files
+// are not hidden unless forced, to avoid overwriting the updates.
+// Unchanged files, already encrypted to the current keys, are skipped
+// unless forced, since re-encrypting changes the private file.
+func hideFiles(identity age.Identity, filesToHide []utils.RepoRelativePath, clean bool, force bool) error {
+	keys, err := loadHideKeys(identity)
 	if err != nil {
 		return err
 	}
 
-	return nil
-}
-
-func hideFiles(identity age.Identity, filesToHide []utils.RepoRelativePath, clean bool) error {
-	recipients, err := utils.GetRecipients(identity)
+	fileList, err := utils.LoadFileList()
 	if err != nil {
-		return fmt.Errorf("failed to load keys, cannot encrypt: %w", err)
+		return err
 	}
-	if len(recipients) == 0 {
-		return fmt.Errorf("no keys added, cannot encrypt")
+
+	settings, err := utils.LoadSettings()
+	if err != nil {
+		return err
 	}
 
 	for _, file := range filesToHide {
-		if strings.HasSuffix(file.Relative(), utils.PrivateExtension) {
+		if utils.IsPrivateFile(file) {
 			return fmt.Errorf("cannot encrypt private file:, %q", file)
 		}
 
-		err := encrypt(file, recipients)
-		if err != nil {
-			return err
+		unchanged := false
+
+		entry, found := fileList.FindFile(file)
+		if !found {
+			entry = fileList.NewFile(file)
 		}
 
-		err = updateFileHash(file)
-		if err != nil {
-			return err
+		if found && !force {
+			status, err := getFileStatus(keys.hasher, entry)
+			if err != nil {
+				return err
+			}
+			switch status {
+			case hiddenRemoteUpdated:
+				return fmt.Errorf("private file of %q was updated, reveal it before hiding, or use 'force' flag to overwrite", file)
+			case hiddenConflict:
+				return fmt.Errorf("%q was modified, and its private file was updated, use 'force' flag to overwrite", file)
+			case hiddenInSync:
+				unchanged, err = isHiddenUnchanged(keys.hasher, entry, keys.recipientsHash)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		if !unchanged {
+			if settings.Layout == utils.BlobLayout && entry.Blob == "" {
+				entry.Blob, err = utils.NewBlobName()
+				if err != nil {
+					return err
+				}
+			}
+
+			fullPath, err := utils.RepoAbsolute(file)
+			if err != nil {
+				return err
+			}
+			plain, err := os.ReadFile(fullPath.Absolute())
+			if err != nil {
+				return err
+			}
+
+			err = hideData(entry, plain, keys, identity, true)
+			if err != nil {
+				return err
+			}
 		}
 
 		if clean {
@@ -109,45 +177,152 @@ func hideFiles(identity age.Identity, filesToHide []utils.RepoRelativePath, clea
 	return nil
 }
 
-func encrypt(file utils.RepoRelativePath, recipients []age.Recipient) error {
-	fullPath, err := utils.RepoAbsolute(file)
+// isHiddenUnchanged checks if the file and its private file are unchanged
+// since last hidden, and if the private file was encrypted to the current keys.
+func isHiddenUnchanged(hasher utils.FileHasher, entry utils.SecureFile, recipientsHash string) (bool, error) {
+	if entry.PrivateHash == "" || entry.RecipientsHash != recipientsHash {
+		return false, nil
+	}
+
+	hash, err := hasher.HashFile(entry.Path)
 	if err != nil {
-		return err
+		return false, err
+	}
+	privatePath, err := utils.PrivatePath(entry)
+	if err != nil {
+		return false, err
+	}
+	privateHash, err := utils.GetFileHash(privatePath)
+	if err != nil {
+		return false, err
 	}
 
-	privatePath := fullPath.Absolute() + utils.PrivateExtension
+	return hash == entry.Hash && privateHash == entry.PrivateHash, nil
+}
 
-	var buf bytes.Buffer
-	encryptedWriter, err := age.Encrypt(&buf, recipients...)
+// hideKeys are the recipients and the file hasher used to hide files.
+type hideKeys struct {
+	recipients     []age.Recipient
+	recipientsHash string
+	hasher         utils.FileHasher
+}
+
+// loadHideKeys loads the keys used to hide files, upgrading the file list
+// to keyed hashes first.
+func loadHideKeys(identity age.Identity) (hideKeys, error) {
+	var keys hideKeys
+	var err error
+
+	keys.recipients, keys.recipientsHash, err = fileRecipients(identity)
 	if err != nil {
-		return err
+		return keys, err
 	}
 
-	privateFile, err := fullPath.Open()
+	err = migrateFileList(identity)
 	if err != nil {
-		return err
+		return keys, err
 	}
 
-	_, err = io.Copy(encryptedWriter, privateFile)
+	fileList, err := utils.LoadFileList()
 	if err != nil {
-		return err
+		return keys, err
 	}
+	keys.hasher, err = loadFileHasher(fileList, identity)
+	return keys, err
+}
 
-	encryptedWriter.Close()
+// hideData encrypts the plain text of a file to its private file,
+// and records the hashes. Revealed tells if the work tree file holds
+// the plain text. Unchanged values of structured files are kept as is,
+// unless encrypted to other keys.
+func hideData(entry utils.SecureFile, plain []byte, keys hideKeys, identity age.Identity, revealed bool) error {
+	privateFile, err := utils.PrivatePath(entry)
+	if err != nil {
+		return err
+	}
+	privatePath, err := utils.RepoAbsolute(privateFile)
 	if err != nil {
 		return err
 	}
+	err = os.MkdirAll(filepath.Dir(privatePath.Absolute()), 0770)
+	if err != nil {
+		return err
+	}
+
+	var previous []byte
+	var reuseIdentities []age.Identity
+	if entry.Format != utils.WholeFile && entry.RecipientsHash == keys.recipientsHash {
+		reuseIdentities, err = fileIdentities(identity)
+		if err != nil {
+			return err
+		}
+		previous, err = os.ReadFile(privatePath.Absolute())
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	encrypted, err := encryptPrivateData(entry, plain, keys.recipients, previous, reuseIdentities)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt %q: %w", entry.Path, err)
+	}
 
-	err = os.WriteFile(privatePath, buf.Bytes(), 0600)
+	err = os.WriteFile(privatePath.Absolute(), encrypted, 0600)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return recordHidden(entry, keys.hasher.Hash(plain), keys.recipientsHash, revealed)
 }
 
-func updateFileHash(file utils.RepoRelativePath) error {
-	hash, err := utils.GetFileHash(file)
+// encryptData encrypts plain text to the given recipients,
+// compressed, padded and armored as configured for the repo.
+func encryptData(plain io.Reader, recipients []age.Recipient) ([]byte, error) {
+	settings, err := utils.LoadSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := io.ReadAll(plain)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	encryptedWriter, err := age.Encrypt(&buf, recipients...)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := utils.EncodePayload(content, settings.Compress, settings.Padding)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = encryptedWriter.Write(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	err = encryptedWriter.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if settings.Armor {
+		return armorData(buf.Bytes())
+	}
+	return buf.Bytes(), nil
+}
+
+// recordHidden records the hashes of a hidden file in the file list,
+// and as synced in the work tree, if the revealed file is up to date.
+func recordHidden(entry utils.SecureFile, hash string, recipientsHash string, revealed bool) error {
+	privatePath, err := utils.PrivatePath(entry)
+	if err != nil {
+		return err
+	}
+	privateHash, err := utils.GetFileHash(privatePath)
 	if err != nil {
 		return err
 	}
@@ -156,13 +331,22 @@ func updateFileHash(file utils.RepoRelativePath) error {
 	if err != nil {
 		return err
 	}
-	for i, fileEntry := range fileList.Files {
-		if fileEntry.Path == file {
-			fileList.Files[i].Hash = hash
-			err = utils.StoreFileList(fileList)
-			return err
-		}
+	entry.Hash = hash
+	entry.PrivateHash = privateHash
+	entry.RecipientsHash = recipientsHash
+
+	err = fileList.UpdateFile(entry)
+	if err != nil {
+		return err
 	}
 
-	return fmt.Errorf("file %q not in file list", file)
+	err = utils.StoreFileList(fileList)
+	if err != nil {
+		return err
+	}
+
+	if !revealed {
+		return nil
+	}
+	return utils.RecordSynced(entry)
 }
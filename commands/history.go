@@ -0,0 +1,177 @@
+package commands
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/erkkah/git-private/utils"
+)
+
+type plainTextCommit struct {
+	Commit string
+	Path   utils.RepoRelativePath
+	Blob   string
+	Author string
+	Date   string
+}
+
+// ScanHistory reports files tracked by git-private, now or at any point in
+// history, that have been committed in plain text.
+func ScanHistory(args []string, usage func()) error {
+	var config struct {
+		JSON bool
+	}
+
+	flags := flag.NewFlagSet("scan-history", flag.ExitOnError)
+	flags.BoolVar(&config.JSON, "json", false, "Output findings as JSON")
+	flags.Usage = usage
+	flags.Parse(args)
+
+	err := utils.EnsureInitialized()
+	if err != nil {
+		return err
+	}
+
+	err = unlockFileList(nil)
+	if err != nil {
+		return err
+	}
+
+	pathspecs, err := everTrackedPathspecs()
+	if err != nil {
+		return err
+	}
+
+	var findings []plainTextCommit
+
+	if len(pathspecs) != 0 {
+		// Leave out private files matched by tracked patterns
+		pathspecs = append(pathspecs, ":(top,exclude,glob)**/*"+utils.PrivateExtension)
+
+		changes, err := utils.GitFileHistory(pathspecs)
+		if err != nil {
+			return err
+		}
+
+		for _, change := range changes {
+			blob, err := utils.GitObjectID(change.Commit + ":" + change.Path.Relative())
+			if err != nil {
+				return err
+			}
+			findings = append(findings, plainTextCommit{
+				Commit: change.Commit,
+				Path:   change.Path,
+				Blob:   blob,
+				Author: change.Author,
+				Date:   change.Date,
+			})
+		}
+	}
+
+	if config.JSON {
+		if findings == nil {
+			findings = []plainTextCommit{}
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false)
+		err = encoder.Encode(findings)
+		if err != nil {
+			return err
+		}
+	} else {
+		if len(findings) == 0 {
+			fmt.Println("No private files committed in plain text")
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 4, ' ', 0)
+		for _, finding := range findings {
+			fmt.Fprintf(w, "%.10s\t%s\t%s\t%s\n", finding.Commit, finding.Path, finding.Author, finding.Date)
+		}
+		w.Flush()
+	}
+
+	if len(findings) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d plain text commit%s of private files found", len(findings), pluralSuffix(len(findings)))
+}
+
+// everTrackedPathspecs collects git pathspecs for all files and patterns
+// in the current file list, and in all committed versions of it.
+func everTrackedPathspecs() ([]string, error) {
+	var pathspecs []string
+	seen := map[string]bool{}
+
+	addPathspec := func(pathspec string) {
+		if !seen[pathspec] {
+			seen[pathspec] = true
+			pathspecs = append(pathspecs, pathspec)
+		}
+	}
+
+	addFiles := func(list utils.FileList) {
+		for _, file := range list.AllFiles() {
+			addPathspec(":(top,literal)" + filepath.ToSlash(file.Path.Relative()))
+		}
+		for _, pattern := range list.Patterns {
+			glob := strings.TrimPrefix(pattern.Pattern, "/")
+			if !strings.Contains(glob, "/") {
+				glob = "**/" + glob
+			}
+			addPathspec(":(top,glob)" + glob)
+		}
+	}
+
+	current, err := utils.LoadFileList()
+	if err != nil {
+		return nil, err
+	}
+	addFiles(current)
+
+	stateFiles, err := stateFilePaths()
+	if err != nil {
+		return nil, err
+	}
+	pathsFile := stateFiles[1]
+
+	settings, err := utils.LoadSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	versions, err := utils.GitFileHistory([]string{":(top,literal)" + pathsFile})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, version := range versions {
+		data, exists, err := utils.GitReadBlob(version.Commit + ":" + pathsFile)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			continue
+		}
+		var list utils.FileList
+		if settings.Layout == utils.BlobLayout {
+			// Versions encrypted using a replaced hash key are skipped
+			list, err = utils.DecryptFileIndex(data)
+		} else {
+			list, err = utils.ParseFileList(data)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "skipping invalid file list in %.10s: %v\n", version.Commit, err)
+			continue
+		}
+		addFiles(list)
+	}
+
+	return pathspecs, nil
+}
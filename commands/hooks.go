@@ -0,0 +1,504 @@
+package commands
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/erkkah/git-private/utils"
+)
+
+const hookBlockStart = "# >>> git-private managed hook >>>"
+const hookBlockEnd = "# <<< git-private managed hook <<<"
+
+var managedHooks = []string{"pre-commit", "post-merge", "post-checkout"}
+
+func Hooks(args []string, usage func()) error {
+	var config struct {
+		AutoHide bool
+	}
+
+	flags := flag.NewFlagSet("hooks <install|uninstall|run>", flag.ExitOnError)
+	flags.BoolVar(&config.AutoHide, "autohide", false, "Hide modified files before committing instead of refusing the commit")
+	flags.Usage = usage
+
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		flags.Parse(args[1:])
+	} else {
+		return fmt.Errorf("no hooks command specified, expected <install|uninstall>")
+	}
+
+	cmd := args[0]
+
+	if cmd == "run" {
+		// Hooks also run on branches and checkouts without private files
+		initialized, err := utils.IsInitialized()
+		if err != nil || !initialized {
+			return err
+		}
+	} else {
+		err := utils.EnsureInitialized()
+		if err != nil {
+			return err
+		}
+	}
+
+	switch cmd {
+	case "install":
+		return installHooks(config.AutoHide)
+	case "uninstall":
+		return uninstallHooks()
+	case "run":
+		if flags.NArg() == 0 {
+			return fmt.Errorf("no hook specified")
+		}
+		return runHook(flags.Arg(0), flags.Args()[1:], config.AutoHide)
+	default:
+		return fmt.Errorf("unknown hooks command %q", cmd)
+	}
+}
+
+const chainedHookSuffix = ".git-private-orig"
+
+// installHooks installs the managed hooks. Existing hooks are renamed,
+// and run by the managed hooks, so that hooks in any language keep working.
+func installHooks(autoHide bool) error {
+	hooksDir, err := utils.GitHooksDir()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(hooksDir.Absolute(), 0770)
+	if err != nil {
+		return err
+	}
+
+	for _, hook := range managedHooks {
+		runFlags := ""
+		if autoHide && hook == "pre-commit" {
+			runFlags = "-autohide "
+		}
+
+		hookFile := filepath.Join(hooksDir.Absolute(), hook)
+		chainedFile := hookFile + chainedHookSuffix
+
+		lines, err := readHookFile(hookFile)
+		if err != nil {
+			return err
+		}
+		if len(lines) != 0 {
+			existing, managed := existingHook(lines)
+			if existing != nil {
+				if exists, err := utils.Exists(utils.AbsolutePath(chainedFile)); exists || err != nil {
+					if err != nil {
+						return err
+					}
+					return fmt.Errorf("cannot install %s hook, both %q and %q exist", hook, hookFile, chainedFile)
+				}
+				if managed {
+					// Installed by earlier versions, in the existing hook
+					err = os.WriteFile(chainedFile, []byte(strings.Join(existing, "\n")+"\n"), 0770)
+				} else {
+					err = os.Rename(hookFile, chainedFile)
+				}
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		hookLines := []string{
+			"#!/bin/sh",
+			hookBlockStart,
+			fmt.Sprintf(`%s hooks run %s%s "$@" || exit $?`, utils.ToolName, runFlags, hook),
+			fmt.Sprintf(`if [ -x "$0%s" ]; then`, chainedHookSuffix),
+			fmt.Sprintf(`	exec "$0%s" "$@"`, chainedHookSuffix),
+			"fi",
+			hookBlockEnd,
+		}
+
+		err = os.WriteFile(hookFile, []byte(strings.Join(hookLines, "\n")+"\n"), 0770)
+		if err != nil {
+			return err
+		}
+		err = os.Chmod(hookFile, 0770)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// existingHook returns the hook content that is not managed, if any,
+// and if the hook has a managed block.
+func existingHook(lines []string) ([]string, bool) {
+	remaining := removeHookBlock(lines)
+	managed := len(remaining) != len(lines)
+
+	content := strings.TrimSpace(strings.Join(remaining, "\n"))
+	if managed && (content == "" || content == "#!/bin/sh") {
+		return nil, true
+	}
+	return remaining, managed
+}
+
+func uninstallHooks() error {
+	hooksDir, err := utils.GitHooksDir()
+	if err != nil {
+		return err
+	}
+
+	for _, hook := range managedHooks {
+		hookFile := filepath.Join(hooksDir.Absolute(), hook)
+		chainedFile := hookFile + chainedHookSuffix
+
+		lines, err := readHookFile(hookFile)
+		if err != nil {
+			return err
+		}
+		if len(lines) == 0 {
+			continue
+		}
+
+		existing, managed := existingHook(lines)
+		if !managed {
+			continue
+		}
+
+		if existing != nil {
+			// Installed by earlier versions, in the existing hook
+			err = os.WriteFile(hookFile, []byte(strings.Join(existing, "\n")+"\n"), 0770)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		err = os.Remove(hookFile)
+		if err != nil {
+			return err
+		}
+		if exists, err := utils.Exists(utils.AbsolutePath(chainedFile)); exists || err != nil {
+			if err != nil {
+				return err
+			}
+			err = os.Rename(chainedFile, hookFile)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func readHookFile(hookFile string) ([]string, error) {
+	contents, err := os.ReadFile(hookFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(strings.TrimRight(string(contents), "\n"), "\n"), nil
+}
+
+func removeHookBlock(lines []string) []string {
+	var remaining []string
+	inBlock := false
+
+	for _, line := range lines {
+		switch {
+		case strings.TrimSpace(line) == hookBlockStart:
+			inBlock = true
+		case strings.TrimSpace(line) == hookBlockEnd:
+			inBlock = false
+		case !inBlock:
+			remaining = append(remaining, line)
+		}
+	}
+
+	return remaining
+}
+
+func runHook(hook string, args []string, autoHide bool) error {
+	switch hook {
+	case "pre-commit":
+		return preCommitHook(autoHide)
+	case "post-merge":
+		err := postUpdateHook("ORIG_HEAD")
+		if err != nil {
+			return err
+		}
+		return postMergeReEncrypt("ORIG_HEAD")
+	case "post-checkout":
+		// Only act on branch checkouts
+		if len(args) < 3 || args[2] != "1" {
+			return nil
+		}
+		// The initial checkout of a clone or worktree has no previous revision
+		if strings.Trim(args[0], "0") == "" {
+			return nil
+		}
+		return postUpdateHook(args[0])
+	default:
+		return fmt.Errorf("unknown hook %q", hook)
+	}
+}
+
+func preCommitHook(autoHide bool) error {
+	err := unlockFileList(nil)
+	if err != nil {
+		return err
+	}
+
+	fileList, err := utils.LoadFileList()
+	if err != nil {
+		return err
+	}
+
+	files, err := utils.ExpandFileList(fileList)
+	if err != nil {
+		return err
+	}
+
+	hasher, err := loadFileHasher(fileList, nil)
+	if err != nil {
+		return err
+	}
+
+	var modified []utils.RepoRelativePath
+
+	for _, file := range files {
+		status, err := getFileStatus(hasher, file)
+		if err != nil {
+			return err
+		}
+		if status == hiddenModified || status == notHidden || status == hiddenConflict {
+			modified = append(modified, file.Path)
+		}
+	}
+
+	if len(modified) == 0 {
+		return nil
+	}
+
+	if !autoHide {
+		var names []string
+		for _, file := range modified {
+			names = append(names, fmt.Sprintf("\t%s", file))
+		}
+		return fmt.Errorf("private files are not hidden:\n%s\nuse '%s hide' before committing",
+			strings.Join(names, "\n"), utils.ToolName)
+	}
+
+	identity, err := loadPrivateKey("")
+	if err != nil {
+		return err
+	}
+
+	err = hideFiles(identity, modified, false, false)
+	if err != nil {
+		return err
+	}
+
+	listFile, err := utils.FileListFile()
+	if err != nil {
+		return err
+	}
+	listFileRelative, err := utils.RepoRelative(listFile)
+	if err != nil {
+		return err
+	}
+
+	// Hiding assigns blob names in the blob layout
+	fileList, err = utils.LoadFileList()
+	if err != nil {
+		return err
+	}
+
+	toAdd := []utils.RepoRelativePath{listFileRelative}
+	for _, file := range modified {
+		entry, _ := fileList.FindFile(file)
+		privatePath, err := utils.PrivatePath(entry)
+		if err != nil {
+			return err
+		}
+		toAdd = append(toAdd, privatePath)
+	}
+
+	return utils.GitAdd(toAdd...)
+}
+
+// postUpdateHook reveals files that are not revealed, or have been
+// updated since the given previous revision.
+func postUpdateHook(previous string) error {
+	err := unlockFileList(nil)
+	if err != nil {
+		return err
+	}
+
+	fileList, err := utils.LoadFileList()
+	if err != nil {
+		return err
+	}
+
+	hasher, err := loadFileHasher(fileList, nil)
+	if err != nil {
+		return err
+	}
+
+	changedFiles, err := utils.GitChangedFiles(previous, "HEAD")
+	if err != nil {
+		return err
+	}
+	changed := map[utils.RepoRelativePath]bool{}
+	for _, file := range changedFiles {
+		changed[file] = true
+	}
+
+	previousFiles := map[utils.RepoRelativePath]utils.SecureFile{}
+	stateFiles, err := stateFilePaths()
+	if err != nil {
+		return err
+	}
+	previousList, exists, err := utils.GitReadBlob(previous + ":" + stateFiles[1])
+	if err != nil {
+		return err
+	}
+	if exists {
+		settings, err := utils.LoadSettings()
+		if err != nil {
+			return err
+		}
+		var list utils.FileList
+		if settings.Layout == utils.BlobLayout {
+			// Fails if the hash key was replaced, then nothing is compared
+			list, _ = utils.DecryptFileIndex(previousList)
+		} else {
+			list, err = utils.ParseFileList(previousList)
+			if err != nil {
+				return err
+			}
+		}
+		// Hashes can only be compared within the same file list version
+		if list.Version == fileList.Version {
+			for _, file := range list.AllFiles() {
+				previousFiles[file.Path] = file
+			}
+		}
+	}
+
+	var toReveal []utils.SecureFile
+
+	for _, file := range fileList.AllFiles() {
+		status, err := getFileStatus(hasher, file)
+		if err != nil {
+			return err
+		}
+
+		switch status {
+		case hiddenNotRevealed, hiddenRemoteUpdated:
+			toReveal = append(toReveal, file)
+		case hiddenConflict:
+			fmt.Fprintf(os.Stderr, "%s: %q was updated, but has local modifications, not revealed\n", utils.ToolName, file.Path)
+		case hiddenModified:
+			privatePath, err := utils.PrivatePath(file)
+			if err != nil {
+				return err
+			}
+			if !changed[privatePath] {
+				continue
+			}
+			// Only overwrite files that were in sync before the update
+			hash, err := hasher.HashFile(file.Path)
+			if err != nil {
+				return err
+			}
+			if previousFile, found := previousFiles[file.Path]; found && previousFile.Hash == hash {
+				toReveal = append(toReveal, file)
+			} else {
+				fmt.Fprintf(os.Stderr, "%s: %q was updated, but has local modifications, not revealed\n", utils.ToolName, file.Path)
+			}
+		}
+	}
+
+	if len(toReveal) == 0 {
+		return nil
+	}
+
+	identity, err := loadPrivateKey("")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: cannot reveal updated files: %v\n", utils.ToolName, err)
+		return nil
+	}
+
+	for _, file := range toReveal {
+		err = decrypt(file, false, identity)
+		if err != nil {
+			return fmt.Errorf("failed to reveal %q: %w", file.Path, err)
+		}
+	}
+
+	fmt.Printf("%s: %v file%s revealed\n", utils.ToolName, len(toReveal), pluralSuffix(len(toReveal)))
+
+	return nil
+}
+
+// postMergeReEncrypt encrypts private files, or the data key in envelope mode,
+// and the hash key to the merged keys, if the merge changed the key list.
+// Files hidden on either branch are not encrypted to keys added on the other.
+func postMergeReEncrypt(previous string) error {
+	changedFiles, err := utils.GitChangedFiles(previous, "HEAD")
+	if err != nil {
+		return err
+	}
+	stateFiles, err := stateFilePaths()
+	if err != nil {
+		return err
+	}
+	keysChanged := false
+	for _, file := range changedFiles {
+		keysChanged = keysChanged || string(file) == stateFiles[0]
+	}
+	if !keysChanged {
+		return nil
+	}
+
+	identity, err := loadPrivateKey("")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: cannot encrypt files to the merged keys: %v\n", utils.ToolName, err)
+		return nil
+	}
+
+	err = rewrapHashKey(identity)
+	if err != nil {
+		return err
+	}
+
+	settings, err := utils.LoadSettings()
+	if err != nil {
+		return err
+	}
+	if settings.Envelope {
+		// Files are encrypted to the data key, only the data key needs to be encrypted again
+		err = rewrapDataKey(identity)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s: data key encrypted to the merged keys, commit to share\n", utils.ToolName)
+		return nil
+	}
+
+	count, err := reEncryptStaleFiles(identity)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		fmt.Printf("%s: %v file%s encrypted to the merged keys, commit to share\n", utils.ToolName, count, pluralSuffix(count))
+	}
+	return nil
+}
@@ -1,13 +1,28 @@
 package commands
 
 import (
+	"flag"
 	"fmt"
 	"os"
 
 	"github.com/erkkah/git-private/utils"
 )
 
-func Init(_ []string, _ func()) error {
+func Init(args []string, usage func()) error {
+	var config struct {
+		Layout string
+	}
+
+	flags := flag.NewFlagSet("init", flag.ExitOnError)
+	flags.StringVar(&config.Layout, "layout", "", "Store private files in the given `layout`, \"mirror\" or \"blobs\"")
+	flags.Usage = usage
+	flags.Parse(args)
+
+	layout, err := parseLayout(config.Layout)
+	if err != nil {
+		return err
+	}
+
 	stateDir, err := utils.StateDir()
 	if err != nil {
 		return err
@@ -26,12 +41,28 @@ func Init(_ []string, _ func()) error {
 		return err
 	}
 
+	if layout == utils.BlobLayout {
+		// The encrypted file index is created when the first key is added
+		return utils.StoreSettings(utils.Settings{Layout: layout})
+	}
+
+	if layout != utils.SiblingLayout {
+		err = utils.StoreSettings(utils.Settings{Layout: layout})
+		if err != nil {
+			return err
+		}
+	}
+
 	err = utils.StoreFileList(utils.FileList{})
 	if err != nil {
 		return err
 	}
 
-	err = utils.GitAddIgnorePattern(fmt.Sprintf("!*%s", utils.PrivateExtension))
+	negation, err := privateFilesIgnoreNegation(layout)
+	if err != nil {
+		return err
+	}
+	err = utils.GitAddIgnorePattern(negation)
 	if err != nil {
 		return err
 	}
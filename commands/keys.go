@@ -83,6 +83,11 @@ func Keys(args []string, usage func()) error {
 			return err
 		}
 
+		err = unlockFileList(identity)
+		if err != nil {
+			return err
+		}
+
 		access := utils.ReadWrite
 		if config.ReadOnly {
 			access = utils.ReadOnly
@@ -91,6 +96,27 @@ func Keys(args []string, usage func()) error {
 		if err != nil {
 			return err
 		}
+		err = rewrapHashKey(identity)
+		if err != nil {
+			return err
+		}
+
+		settings, err := utils.LoadSettings()
+		if err != nil {
+			return err
+		}
+		if settings.Layout == utils.BlobLayout {
+			// The file index is encrypted using the hash key, create it
+			// as soon as there are keys to protect it.
+			err = migrateFileList(identity)
+			if err != nil {
+				return err
+			}
+		}
+		if settings.Envelope {
+			// Only the data key needs to be encrypted to the new key
+			return rewrapDataKey(identity)
+		}
 
 		inSync, err := areFilesInSync()
 		if err != nil {
@@ -118,6 +144,33 @@ func Keys(args []string, usage func()) error {
 			return err
 		}
 
+		err = unlockFileList(identity)
+		if err != nil {
+			return err
+		}
+
+		settings, err := utils.LoadSettings()
+		if err != nil {
+			return err
+		}
+		if settings.Envelope {
+			// The removed key might have a copy of the data key, replace it
+			identities, err := fileIdentities(identity)
+			if err != nil {
+				return err
+			}
+			err = removeKey(identity, config.PubKeyID)
+			if err != nil {
+				return err
+			}
+			err = rotateDataKey(identity, identities)
+			if err != nil {
+				return err
+			}
+			// The removed key might have a copy of the hash key too
+			return rotateHashKey(identity)
+		}
+
 		err = removeKey(identity, config.PubKeyID)
 		if err != nil {
 			return err
@@ -126,6 +179,10 @@ func Keys(args []string, usage func()) error {
 		if err != nil {
 			return fmt.Errorf("failed to re-encrypt files after key removal")
 		}
+		err = rotateHashKey(identity)
+		if err != nil {
+			return err
+		}
 
 	case cmd == "generate":
 		if config.KeyFile == "" {
@@ -176,11 +233,11 @@ func reHideFiles(identity age.Identity) error {
 	}
 
 	var paths []utils.RepoRelativePath
-	for _, file := range fileList.Files {
+	for _, file := range fileList.AllFiles() {
 		paths = append(paths, file.Path)
 	}
 
-	return hideFiles(identity, paths, false)
+	return hideFiles(identity, paths, false, false)
 }
 
 func listKeys(identity age.Identity) error {
@@ -327,7 +384,7 @@ func parseSSHIdentity(key []byte) (age.Identity, error) {
 		if _, needsPassword := err.(*ssh.PassphraseMissingError); needsPassword {
 			passphrase, err := readPassphrase("Enter SSH key passphrase:")
 			if err != nil {
-				return nil, fmt.Errorf("failed to read passphrase")
+				return nil, fmt.Errorf("failed to read passphrase: %w", err)
 			}
 			parsedIdentity, err := ssh.ParseRawPrivateKeyWithPassphrase([]byte(key), passphrase)
 			if err != nil {
@@ -355,12 +412,10 @@ func parseSSHIdentity(key []byte) (age.Identity, error) {
 }
 
 func parseAGEIdentity(key []byte) (age.Identity, error) {
-	var ageMagic = []byte("age-encryption.org/")
-
 	if bytes.HasPrefix(key, ageMagic) {
 		passphrase, err := readPassphrase("Enter AGE key passphrase:")
 		if err != nil {
-			return nil, fmt.Errorf("failed to read passphrase")
+			return nil, fmt.Errorf("failed to read passphrase: %w", err)
 		}
 
 		identity, err := age.NewScryptIdentity(string(passphrase))
@@ -391,9 +446,15 @@ func parseAGEIdentity(key []byte) (age.Identity, error) {
 }
 
 func readPassphrase(prompt string) ([]byte, error) {
+	stdinFd := os.Stdin.Fd()
+
+	// Stdin is data, not the user, when run as a git filter
+	if !term.IsTerminal(int(stdinFd)) {
+		return nil, fmt.Errorf("stdin is not a terminal")
+	}
+
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, syscall.SIGINT)
-	stdinFd := os.Stdin.Fd()
 	state, _ := term.GetState(int(stdinFd))
 	go func() {
 		signal := <-signals
@@ -407,9 +468,10 @@ func readPassphrase(prompt string) ([]byte, error) {
 		signal.Reset(syscall.SIGINT)
 	}()
 
-	fmt.Print(prompt)
+	// Prompts go to stderr, to keep stdout clean for output
+	fmt.Fprint(os.Stderr, prompt)
 	passphrase, err := term.ReadPassword(int(stdinFd))
-	fmt.Println()
+	fmt.Fprintln(os.Stderr)
 
 	return passphrase, err
 }
@@ -0,0 +1,319 @@
+package commands
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"filippo.io/age"
+
+	"github.com/erkkah/git-private/utils"
+)
+
+// Log lists the commits changing the private file of a file, with diffs
+// of the decrypted contents. Values are redacted unless asked not to.
+func Log(args []string, usage func()) error {
+	var config struct {
+		KeyFromFile string
+		Unredacted  bool
+	}
+
+	flags := flag.NewFlagSet("log <file> [-unredacted]", flag.ExitOnError)
+	flags.StringVar(&config.KeyFromFile, "keyfile", "", "Load private key from `file`")
+	flags.BoolVar(&config.Unredacted, "unredacted", false, "Show decrypted values in diffs")
+	flags.Usage = usage
+	flags.Parse(args)
+
+	file, err := fileArgument(flags)
+	if err != nil {
+		return err
+	}
+
+	err = utils.EnsureInitialized()
+	if err != nil {
+		return err
+	}
+
+	identity, err := loadPrivateKey(config.KeyFromFile)
+	if err != nil {
+		return err
+	}
+
+	err = unlockFileList(identity)
+	if err != nil {
+		return err
+	}
+
+	path, err := repoRelativeArgument(file)
+	if err != nil {
+		return err
+	}
+
+	fileList, err := utils.LoadFileList()
+	if err != nil {
+		return err
+	}
+	entry, found := fileList.FindFile(path)
+	if !found {
+		return fmt.Errorf("%q is not tracked", path)
+	}
+
+	privatePath, err := utils.PrivatePath(entry)
+	if err != nil {
+		return err
+	}
+
+	changes, err := utils.GitFileLog(privatePath)
+	if err != nil {
+		return err
+	}
+
+	identities, err := fileIdentities(identity)
+	if err != nil {
+		return err
+	}
+	// Decrypted data keys, by the contents of the data key file
+	dataKeys := map[string][]age.Identity{}
+
+	versions := make([]fileVersion, len(changes)+1)
+	for i, change := range changes {
+		// Follow moves and relayouts using the file list of each revision,
+		// falling back to the entry of the newer version
+		if revisionEntry, found := revisionLogEntry(change); found {
+			entry = revisionEntry
+		}
+		versions[i].entry = entry
+
+		encrypted, exists, err := utils.GitReadBlob(change.Commit + ":" + filepath.ToSlash(change.Path.Relative()))
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+
+		revisionIdentities := identities
+		keyData, found, err := readRevisionStateFile(change.Commit, utils.DataKeyFile)
+		if err != nil {
+			return err
+		}
+		if found {
+			revisionKeys, decrypted := dataKeys[string(keyData)]
+			if !decrypted {
+				decryptedKeys, err := utils.DecryptDataKeys(keyData, identity)
+				if err != nil {
+					versions[i].err = err
+					continue
+				}
+				for _, dataKey := range decryptedKeys {
+					revisionKeys = append(revisionKeys, dataKey)
+				}
+				dataKeys[string(keyData)] = revisionKeys
+			}
+			revisionIdentities = append(append([]age.Identity{}, revisionKeys...), identities...)
+		}
+
+		versions[i].plain, versions[i].err = decryptPrivateData(entry, encrypted, revisionIdentities...)
+		versions[i].exists = versions[i].err == nil
+	}
+	versions[len(changes)].entry = entry
+
+	for i, change := range changes {
+		fmt.Printf("commit %s\nAuthor: %s\nDate:   %s\n\n", change.Commit, change.Author, change.Date)
+
+		// Versions are listed newest first
+		before, after := versions[i+1], versions[i]
+		switch {
+		case before.err != nil:
+			fmt.Printf("    cannot decrypt previous version: %v\n\n", before.err)
+		case after.err != nil:
+			fmt.Printf("    cannot decrypt: %v\n\n", after.err)
+		default:
+			printVersionDiff(before, after, !config.Unredacted)
+		}
+	}
+
+	return nil
+}
+
+// revisionLogEntry finds the file list entry of the private file
+// changed in a logged commit.
+func revisionLogEntry(change utils.GitFileChange) (utils.SecureFile, bool) {
+	fileList, layout, err := revisionFileList(change.Commit)
+	if err != nil {
+		return utils.SecureFile{}, false
+	}
+	for _, file := range fileList.AllFiles() {
+		privatePath, err := layout.PrivatePath(file)
+		if err == nil && privatePath == change.Path {
+			return file, true
+		}
+	}
+	return utils.SecureFile{}, false
+}
+
+// fileVersion is the decrypted contents of a file at some revision.
+type fileVersion struct {
+	entry  utils.SecureFile
+	plain  []byte
+	exists bool
+	err    error
+}
+
+// Lines of context around changes
+const diffContext = 3
+
+func printVersionDiff(before fileVersion, after fileVersion, redact bool) {
+	from := splitLines(before.plain)
+	to := splitLines(after.plain)
+
+	diff := utils.DiffLines(from, to)
+	changed := false
+	for _, line := range diff {
+		changed = changed || line.Kind != ' '
+	}
+	if !changed {
+		fmt.Printf("    contents unchanged\n\n")
+		return
+	}
+
+	if redact {
+		from = redactLines(before.entry, before.plain)
+		to = redactLines(after.entry, after.plain)
+	}
+
+	fromName, toName := "a/"+string(before.entry.Path), "b/"+string(after.entry.Path)
+	if !before.exists {
+		fromName = "/dev/null"
+	}
+	if !after.exists {
+		toName = "/dev/null"
+	}
+	fmt.Printf("--- %s\n+++ %s\n", fromName, toName)
+
+	// Line positions in both versions before each diff line
+	fromPos := make([]int, len(diff)+1)
+	toPos := make([]int, len(diff)+1)
+	for k, line := range diff {
+		fromPos[k+1], toPos[k+1] = fromPos[k], toPos[k]
+		if line.From >= 0 {
+			fromPos[k+1]++
+		}
+		if line.To >= 0 {
+			toPos[k+1]++
+		}
+	}
+
+	for k := 0; k < len(diff); {
+		for k < len(diff) && diff[k].Kind == ' ' {
+			k++
+		}
+		if k == len(diff) {
+			break
+		}
+
+		// Extend the hunk over changes separated by little context
+		start := k - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := k
+		for end < len(diff) {
+			if diff[end].Kind != ' ' {
+				end++
+				continue
+			}
+			next := end
+			for next < len(diff) && diff[next].Kind == ' ' {
+				next++
+			}
+			if next == len(diff) || next-end > 2*diffContext {
+				end += diffContext
+				if end > len(diff) {
+					end = len(diff)
+				}
+				break
+			}
+			end = next
+		}
+
+		fmt.Printf("@@ -%s +%s @@\n",
+			hunkRange(fromPos[start], fromPos[end]-fromPos[start]),
+			hunkRange(toPos[start], toPos[end]-toPos[start]))
+		for _, line := range diff[start:end] {
+			if line.From >= 0 {
+				fmt.Printf("%c%s\n", line.Kind, from[line.From])
+			} else {
+				fmt.Printf("%c%s\n", line.Kind, to[line.To])
+			}
+		}
+
+		k = end
+	}
+	fmt.Println()
+}
+
+func hunkRange(before int, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", before)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", before+1)
+	}
+	return fmt.Sprintf("%d,%d", before+1, count)
+}
+
+func splitLines(text []byte) []string {
+	if len(text) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(text), "\n"), "\n")
+}
+
+const redactedValue = "***"
+
+// redactLines hides values of structured files, keeping keys, comments
+// and line structure. Lines of other files are hidden as a whole.
+func redactLines(entry utils.SecureFile, plain []byte) []string {
+	format := entry.Format
+	known := true
+	if format == utils.WholeFile {
+		format, known = utils.GuessFormat(entry.Path)
+	}
+
+	var values []utils.Value
+	var err error
+	if known {
+		values, err = format.Values(plain)
+	}
+
+	if !known || err != nil {
+		lines := splitLines(plain)
+		for i, line := range lines {
+			if line != "" {
+				lines[i] = redactedValue
+			}
+		}
+		return lines
+	}
+
+	var redacted strings.Builder
+	last := 0
+	for _, value := range values {
+		redacted.Write(plain[last:value.Start])
+		// Keep line breaks, so that lines match the plain text
+		for i, line := range strings.Split(string(plain[value.Start:value.End]), "\n") {
+			if i > 0 {
+				redacted.WriteByte('\n')
+			}
+			if line != "" {
+				redacted.WriteString(redactedValue)
+			}
+		}
+		last = value.End
+	}
+	redacted.Write(plain[last:])
+
+	return splitLines([]byte(redacted.String()))
+}
@@ -0,0 +1,565 @@
+package commands
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"filippo.io/age"
+
+	"github.com/erkkah/git-private/utils"
+)
+
+// MergeInstall registers the merge driver in the local git config,
+// and assigns it to private files in .gitattributes.
+func MergeInstall(args []string, usage func()) error {
+	flags := flag.NewFlagSet("merge-install", flag.ExitOnError)
+	flags.Usage = usage
+	flags.Parse(args)
+
+	err := utils.EnsureInitialized()
+	if err != nil {
+		return err
+	}
+
+	mergeConfig := map[string]string{
+		"name":   fmt.Sprintf("%s merge driver", utils.ToolName),
+		"driver": fmt.Sprintf("%s merge-driver %%O %%A %%B %%P", utils.ToolName),
+	}
+
+	for key, value := range mergeConfig {
+		err = utils.GitSetConfig(fmt.Sprintf("merge.%s.%s", filterName, key), value)
+		if err != nil {
+			return err
+		}
+	}
+
+	stateFiles, err := stateFilePaths()
+	if err != nil {
+		return err
+	}
+
+	wrappedKeyFiles, err := wrappedKeyPaths()
+	if err != nil {
+		return err
+	}
+
+	privatePatterns, err := utils.PrivateFilePatterns()
+	if err != nil {
+		return err
+	}
+
+	patterns := append(privatePatterns, stateFiles...)
+	patterns = append(patterns, wrappedKeyFiles...)
+
+	for _, pattern := range patterns {
+		err = utils.GitAddAttributes(pattern, "merge="+filterName)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// stateFilePaths returns the repo relative paths of the key list and the file list.
+func stateFilePaths() ([]string, error) {
+	return repoStatePaths(utils.KeysFile, utils.FileListFile)
+}
+
+// wrappedKeyPaths returns the repo relative paths of the data key and the hash key.
+func wrappedKeyPaths() ([]string, error) {
+	return repoStatePaths(utils.DataKeyFile, utils.HashKeyFile)
+}
+
+func repoStatePaths(stateFiles ...func() (utils.AbsolutePath, error)) ([]string, error) {
+	var paths []string
+
+	for _, stateFile := range stateFiles {
+		absolute, err := stateFile()
+		if err != nil {
+			return nil, err
+		}
+		relative, err := utils.RepoRelative(absolute)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, filepath.ToSlash(relative.Relative()))
+	}
+
+	return paths, nil
+}
+
+// MergeDriver merges private files, called by git as
+// "merge-driver <ancestor> <ours> <theirs> <path>".
+// The merged result replaces "ours".
+func MergeDriver(args []string, _ func()) error {
+	if len(args) != 4 {
+		return fmt.Errorf("expected <ancestor> <ours> <theirs> <path> arguments")
+	}
+
+	ancestor, ours, theirs := args[0], args[1], args[2]
+	path := filepath.ToSlash(args[3])
+
+	stateFiles, err := stateFilePaths()
+	if err != nil {
+		return err
+	}
+	keysFile, pathsFile := stateFiles[0], stateFiles[1]
+
+	if path == pathsFile {
+		err = unlockFileList(nil)
+		if err != nil {
+			return err
+		}
+		return mergeFileLists(ancestor, ours, theirs)
+	}
+
+	identity, err := loadPrivateKey("")
+	if err != nil {
+		return err
+	}
+
+	if path == keysFile {
+		return mergeKeyLists(identity, ancestor, ours, theirs)
+	}
+
+	wrappedKeyFiles, err := wrappedKeyPaths()
+	if err != nil {
+		return err
+	}
+	for _, wrappedKeyFile := range wrappedKeyFiles {
+		if path == wrappedKeyFile {
+			return mergeWrappedKeys(identity, ours, theirs, path)
+		}
+	}
+
+	return mergePrivateFile(identity, ancestor, ours, theirs, utils.RepoRelativePath(path))
+}
+
+func mergePrivateFile(identity age.Identity, ancestor string, ours string, theirs string, path utils.RepoRelativePath) error {
+	if !utils.IsPrivateFile(path) {
+		return fmt.Errorf("cannot merge %q, not a private file", path)
+	}
+
+	oursData, err := os.ReadFile(ours)
+	if err != nil {
+		return err
+	}
+	if len(oursData) != 0 && !isEncrypted(oursData) {
+		return mergeStructuredPrivateFile(ancestor, ours, theirs, path)
+	}
+
+	tempDir, err := os.MkdirTemp("", utils.ToolName)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+
+	identities, err := fileIdentities(identity)
+	if err != nil {
+		return err
+	}
+
+	var revealed []utils.AbsolutePath
+
+	for _, version := range []string{ours, ancestor, theirs} {
+		decrypted, err := decryptMergeVersion(version, identities)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt %q for merge: %w", path, err)
+		}
+		revealedVersion := utils.AbsolutePath(filepath.Join(tempDir, fmt.Sprintf("version%d", len(revealed))))
+		err = os.WriteFile(revealedVersion.Absolute(), decrypted, 0600)
+		if err != nil {
+			return err
+		}
+		revealed = append(revealed, revealedVersion)
+	}
+
+	merged, conflicts, err := utils.GitMergeFile(revealed[0], revealed[1], revealed[2])
+	if err != nil {
+		return err
+	}
+
+	if conflicts == 0 {
+		recipients, _, err := fileRecipients(identity)
+		if err != nil {
+			return err
+		}
+		encrypted, err := encryptData(bytes.NewReader(merged), recipients)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(ours, encrypted, 0600)
+	}
+
+	plainFile, err := plainPathOf(path)
+	if err != nil {
+		return err
+	}
+	plainPath, err := utils.RepoAbsolute(plainFile)
+	if err != nil {
+		return err
+	}
+
+	exists, err := utils.Exists(plainPath)
+	if err != nil {
+		return err
+	}
+	if exists {
+		current, err := os.ReadFile(plainPath.Absolute())
+		if err != nil {
+			return err
+		}
+		oursRevealed, err := os.ReadFile(revealed[0].Absolute())
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(current, oursRevealed) {
+			return fmt.Errorf("conflicts in %q, and revealed file %q has local modifications, merge manually", path, plainPath)
+		}
+	}
+
+	err = os.WriteFile(plainPath.Absolute(), merged, 0660)
+	if err != nil {
+		return err
+	}
+
+	return fmt.Errorf("%d conflict%s in %q, resolve in revealed file and then 'hide'", conflicts, pluralSuffix(conflicts), path)
+}
+
+// plainPathOf returns the path of the original file of a private file.
+func plainPathOf(privateFile utils.RepoRelativePath) (utils.RepoRelativePath, error) {
+	if strings.HasSuffix(privateFile.Relative(), utils.PrivateExtension) {
+		return utils.RepoRelativePath(strings.TrimSuffix(privateFile.Relative(), utils.PrivateExtension)), nil
+	}
+
+	err := unlockFileList(nil)
+	if err != nil {
+		return "", err
+	}
+	fileList, err := utils.LoadFileList()
+	if err != nil {
+		return "", err
+	}
+	for _, file := range fileList.AllFiles() {
+		privatePath, err := utils.PrivatePath(file)
+		if err != nil {
+			return "", err
+		}
+		if privatePath == privateFile {
+			return file.Path, nil
+		}
+	}
+	return "", fmt.Errorf("no file list entry for %q", privateFile)
+}
+
+// mergeStructuredPrivateFile merges structured private files as text.
+// Values are encrypted one by one, so only values changed on both sides conflict.
+func mergeStructuredPrivateFile(ancestor string, ours string, theirs string, path utils.RepoRelativePath) error {
+	merged, conflicts, err := utils.GitMergeFile(utils.AbsolutePath(ours), utils.AbsolutePath(ancestor), utils.AbsolutePath(theirs))
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(ours, merged, 0600)
+	if err != nil {
+		return err
+	}
+
+	if conflicts != 0 {
+		return fmt.Errorf("%d conflict%s in %q, resolve in the private file and then 'reveal'", conflicts, pluralSuffix(conflicts), path)
+	}
+	return nil
+}
+
+func decryptMergeVersion(file string, identities []age.Identity) ([]byte, error) {
+	encrypted, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	// No common ancestor
+	if len(encrypted) == 0 {
+		return encrypted, nil
+	}
+
+	return decryptData(bytes.NewReader(encrypted), identities...)
+}
+
+func mergeKeyLists(identity age.Identity, ancestor string, ours string, theirs string) error {
+	var lists []utils.KeyList
+
+	for _, version := range []string{ancestor, ours, theirs} {
+		encrypted, err := os.ReadFile(version)
+		if err != nil {
+			return err
+		}
+		var list utils.KeyList
+		// An empty ancestor means there is no common ancestor
+		if len(encrypted) != 0 {
+			list, err = utils.DecryptKeyList(bytes.NewReader(encrypted), identity)
+			if err != nil {
+				return err
+			}
+		}
+		lists = append(lists, list)
+	}
+
+	base, oursList, theirsList := lists[0], lists[1], lists[2]
+
+	baseKeys := map[string]utils.Key{}
+	for _, key := range base.Keys {
+		baseKeys[key.ID] = key
+	}
+	theirKeys := map[string]utils.Key{}
+	for _, key := range theirsList.Keys {
+		theirKeys[key.ID] = key
+	}
+
+	var merged utils.KeyList
+	var conflicts []string
+
+	for _, key := range oursList.Keys {
+		baseKey, inBase := baseKeys[key.ID]
+		theirKey, inTheirs := theirKeys[key.ID]
+
+		switch {
+		case inTheirs && key == theirKey:
+			merged.Keys = append(merged.Keys, key)
+		case inTheirs && inBase && key == baseKey:
+			merged.Keys = append(merged.Keys, theirKey)
+		case inTheirs && inBase && theirKey == baseKey:
+			merged.Keys = append(merged.Keys, key)
+		case inTheirs:
+			conflicts = append(conflicts, fmt.Sprintf("key %q differs between branches", key.ID))
+		case inBase && key == baseKey:
+			// Removed in theirs
+		case inBase:
+			conflicts = append(conflicts, fmt.Sprintf("key %q was changed in ours, but removed in theirs", key.ID))
+		default:
+			merged.Keys = append(merged.Keys, key)
+		}
+		delete(theirKeys, key.ID)
+	}
+
+	for _, key := range theirsList.Keys {
+		if _, remaining := theirKeys[key.ID]; !remaining {
+			continue
+		}
+		baseKey, inBase := baseKeys[key.ID]
+
+		switch {
+		case inBase && key == baseKey:
+			// Removed in ours
+		case inBase:
+			conflicts = append(conflicts, fmt.Sprintf("key %q was removed in ours, but changed in theirs", key.ID))
+		default:
+			merged.Keys = append(merged.Keys, key)
+		}
+	}
+
+	if len(conflicts) > 0 {
+		return fmt.Errorf("cannot merge key lists:\n%s", strings.Join(conflicts, "\n"))
+	}
+
+	oursFile, err := filepath.Abs(ours)
+	if err != nil {
+		return err
+	}
+
+	err = utils.StoreKeyListTo(identity, merged, utils.AbsolutePath(oursFile))
+	if err != nil {
+		return err
+	}
+
+	// Files hidden on either branch are not encrypted to keys added on the other
+	if merged.Fingerprint() != oursList.Fingerprint() || merged.Fingerprint() != theirsList.Fingerprint() {
+		fmt.Fprintf(os.Stderr, "%s: keys were merged, use '%s reencrypt' after merging to encrypt private files to the merged keys\n",
+			utils.ToolName, utils.ToolName)
+	}
+	return nil
+}
+
+// mergeWrappedKeys merges the data key or the hash key. The keys are
+// encrypted to the key list of each branch, so when both branches have the
+// same key, ours is kept, and encrypted to the merged key list after the merge.
+func mergeWrappedKeys(identity age.Identity, ours string, theirs string, path string) error {
+	var keys [][]byte
+
+	for _, version := range []string{ours, theirs} {
+		wrapped, err := os.ReadFile(version)
+		if err != nil {
+			return err
+		}
+		key, err := utils.UnwrapSecret(bytes.NewReader(wrapped), identity)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt %q for merge: %w", path, err)
+		}
+		keys = append(keys, key)
+	}
+
+	if !bytes.Equal(keys[0], keys[1]) {
+		return fmt.Errorf("cannot merge %q, the key was replaced on one branch, "+
+			"resolve using the new key and hide the files changed on the other branch again", path)
+	}
+
+	fmt.Fprintf(os.Stderr, "%s: %q merged, use '%s reencrypt' after merging to encrypt it to the merged keys\n",
+		utils.ToolName, path, utils.ToolName)
+	return nil
+}
+
+func mergeFileLists(ancestor string, ours string, theirs string) error {
+	settings, err := utils.LoadSettings()
+	if err != nil {
+		return err
+	}
+	loadFileList, storeFileList := utils.LoadFileListFrom, utils.StoreFileListTo
+	if settings.Layout == utils.BlobLayout {
+		loadFileList, storeFileList = utils.LoadFileIndexFrom, utils.StoreFileIndexTo
+	}
+
+	var lists []utils.FileList
+
+	for _, version := range []string{ancestor, ours, theirs} {
+		absolute, err := filepath.Abs(version)
+		if err != nil {
+			return err
+		}
+		var list utils.FileList
+		// An empty ancestor means there is no common ancestor
+		if info, err := os.Stat(absolute); err != nil || info.Size() != 0 {
+			list, err = loadFileList(utils.AbsolutePath(absolute))
+			if err != nil {
+				return err
+			}
+		}
+		lists = append(lists, list)
+	}
+
+	base, oursList, theirsList := lists[0], lists[1], lists[2]
+
+	if oursList.Version != theirsList.Version {
+		return fmt.Errorf("cannot merge file lists of different versions, use 'hide' on both branches before merging")
+	}
+
+	merged := oursList
+	var conflicts []string
+	merged.Files, conflicts = mergeSecureFiles(base.Files, oursList.Files, theirsList.Files)
+
+	basePatterns := map[string]utils.SecurePattern{}
+	for _, pattern := range base.Patterns {
+		basePatterns[pattern.Pattern] = pattern
+	}
+	theirPatterns := map[string]utils.SecurePattern{}
+	for _, pattern := range theirsList.Patterns {
+		theirPatterns[pattern.Pattern] = pattern
+	}
+
+	merged.Patterns = nil
+
+	for _, pattern := range oursList.Patterns {
+		basePattern, inBase := basePatterns[pattern.Pattern]
+		theirPattern, inTheirs := theirPatterns[pattern.Pattern]
+
+		switch {
+		case inTheirs:
+			files, fileConflicts := mergeSecureFiles(basePattern.Files, pattern.Files, theirPattern.Files)
+			conflicts = append(conflicts, fileConflicts...)
+			pattern.Files = files
+			merged.Patterns = append(merged.Patterns, pattern)
+		case inBase:
+			// Removed in theirs
+		default:
+			merged.Patterns = append(merged.Patterns, pattern)
+		}
+		delete(theirPatterns, pattern.Pattern)
+	}
+
+	for _, pattern := range theirsList.Patterns {
+		if _, remaining := theirPatterns[pattern.Pattern]; !remaining {
+			continue
+		}
+		if _, inBase := basePatterns[pattern.Pattern]; !inBase {
+			merged.Patterns = append(merged.Patterns, pattern)
+		}
+	}
+
+	oursFile, err := filepath.Abs(ours)
+	if err != nil {
+		return err
+	}
+
+	// Conflicting entries are kept as in ours, everything else is merged
+	err = storeFileList(merged, utils.AbsolutePath(oursFile))
+	if err != nil {
+		return err
+	}
+
+	if len(conflicts) > 0 {
+		return fmt.Errorf("cannot merge file lists:\n%s\nresolve the conflicting files, 'hide' them and add the file list", strings.Join(conflicts, "\n"))
+	}
+	return nil
+}
+
+func mergeSecureFiles(base []utils.SecureFile, ours []utils.SecureFile, theirs []utils.SecureFile) ([]utils.SecureFile, []string) {
+	baseFiles := map[utils.RepoRelativePath]utils.SecureFile{}
+	for _, file := range base {
+		baseFiles[file.Path] = file
+	}
+	theirFiles := map[utils.RepoRelativePath]utils.SecureFile{}
+	for _, file := range theirs {
+		theirFiles[file.Path] = file
+	}
+
+	var merged []utils.SecureFile
+	var conflicts []string
+
+	for _, file := range ours {
+		baseFile, inBase := baseFiles[file.Path]
+		theirFile, inTheirs := theirFiles[file.Path]
+
+		switch {
+		case inTheirs && inBase && file == baseFile:
+			merged = append(merged, theirFile)
+		case inTheirs && file != theirFile && (!inBase || theirFile != baseFile):
+			// Both sides hid new versions. The private files are merged on their own,
+			// and hashes of the merged version are updated by the next 'hide'.
+			if file.Format != theirFile.Format || file.Blob != theirFile.Blob {
+				conflicts = append(conflicts, fmt.Sprintf("%q is stored differently on each branch", file.Path))
+			}
+			merged = append(merged, file)
+		case inTheirs:
+			merged = append(merged, file)
+		case inBase && file == baseFile:
+			// Removed in theirs
+		case inBase:
+			conflicts = append(conflicts, fmt.Sprintf("%q was changed in ours, but removed in theirs", file.Path))
+			merged = append(merged, file)
+		default:
+			merged = append(merged, file)
+		}
+		delete(theirFiles, file.Path)
+	}
+
+	for _, file := range theirs {
+		if _, remaining := theirFiles[file.Path]; !remaining {
+			continue
+		}
+		baseFile, inBase := baseFiles[file.Path]
+
+		switch {
+		case inBase && file == baseFile:
+			// Removed in ours
+		case inBase:
+			conflicts = append(conflicts, fmt.Sprintf("%q was removed in ours, but changed in theirs", file.Path))
+		default:
+			merged = append(merged, file)
+		}
+	}
+
+	return merged, conflicts
+}
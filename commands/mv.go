@@ -0,0 +1,402 @@
+package commands
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/erkkah/git-private/utils"
+)
+
+// Move moves a tracked file, or a directory containing tracked files,
+// together with the private files, without re-encrypting.
+func Move(args []string, usage func()) error {
+	flags := flag.NewFlagSet("mv <source> <destination>", flag.ExitOnError)
+	flags.Usage = usage
+	flags.Parse(args)
+
+	err := utils.EnsureInitialized()
+	if err != nil {
+		return err
+	}
+
+	err = unlockFileList(nil)
+	if err != nil {
+		return err
+	}
+
+	if flags.NArg() != 2 {
+		return fmt.Errorf("expected <source> <destination> arguments")
+	}
+
+	source, err := repoRelativeArgument(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	destination, err := repoRelativeArgument(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	sourceAbsolute, err := utils.RepoAbsolute(source)
+	if err != nil {
+		return err
+	}
+	destinationAbsolute, err := utils.RepoAbsolute(destination)
+	if err != nil {
+		return err
+	}
+
+	// Moving into an existing directory keeps the name, like mv
+	if info, err := os.Stat(destinationAbsolute.Absolute()); err == nil && info.IsDir() {
+		destination = utils.RepoRelativePath(filepath.Join(destination.Relative(), filepath.Base(source.Relative())))
+	}
+
+	if info, err := os.Stat(sourceAbsolute.Absolute()); err == nil && info.IsDir() {
+		return moveDirectory(source, destination)
+	}
+	return moveFile(source, destination)
+}
+
+func repoRelativeArgument(file string) (utils.RepoRelativePath, error) {
+	absolute, err := filepath.Abs(file)
+	if err != nil {
+		return "", err
+	}
+	return utils.RepoRelative(utils.AbsolutePath(absolute))
+}
+
+func moveFile(source utils.RepoRelativePath, destination utils.RepoRelativePath) error {
+	fileList, err := utils.LoadFileList()
+	if err != nil {
+		return err
+	}
+
+	if !fileList.Covers(source) {
+		return fmt.Errorf("%q is not tracked", source)
+	}
+	if _, found := fileList.FindFile(destination); found {
+		return fmt.Errorf("%q is already tracked", destination)
+	}
+
+	// Files matching patterns have no entry until hidden
+	entry, found := fileList.FindFile(source)
+	if !found {
+		entry = fileList.NewFile(source)
+		err = fileList.UpdateFile(entry)
+		if err != nil {
+			return err
+		}
+	}
+
+	sourcePrivate, err := utils.PrivatePath(entry)
+	if err != nil {
+		return err
+	}
+	entry.Path = destination
+	destinationPrivate, err := utils.PrivatePath(entry)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range []utils.RepoRelativePath{destination, destinationPrivate} {
+		if file == sourcePrivate {
+			continue
+		}
+		err = ensureNotExisting(file)
+		if err != nil {
+			return err
+		}
+	}
+
+	move := func(path string) (string, bool) {
+		if path == filepath.ToSlash(source.Relative()) {
+			return filepath.ToSlash(destination.Relative()), true
+		}
+		return "", false
+	}
+
+	ignoreChanges, err := moveEntries(&fileList, move)
+	if err != nil {
+		return err
+	}
+
+	err = ignoreChanges.addNew()
+	if err != nil {
+		return err
+	}
+
+	err = moveInWorkTree(source, destination)
+	if err != nil {
+		return err
+	}
+	if destinationPrivate != sourcePrivate {
+		err = moveInWorkTree(sourcePrivate, destinationPrivate)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = moveSyncState(move)
+	if err != nil {
+		return err
+	}
+
+	err = utils.StoreFileList(fileList)
+	if err != nil {
+		return err
+	}
+
+	return ignoreChanges.removeOld()
+}
+
+func moveDirectory(source utils.RepoRelativePath, destination utils.RepoRelativePath) error {
+	fileList, err := utils.LoadFileList()
+	if err != nil {
+		return err
+	}
+
+	err = ensureNotExisting(destination)
+	if err != nil {
+		return err
+	}
+
+	sourcePrefix := filepath.ToSlash(source.Relative()) + "/"
+	destinationPrefix := filepath.ToSlash(destination.Relative()) + "/"
+
+	if strings.HasPrefix(destinationPrefix, sourcePrefix) {
+		return fmt.Errorf("cannot move %q into itself", source)
+	}
+
+	// Private files stored outside the directory are moved one by one
+	var privateMoves [][2]utils.RepoRelativePath
+	for _, file := range fileList.AllFiles() {
+		to, moved := moveFilePath(file.Path, sourcePrefix, destinationPrefix)
+		if !moved {
+			continue
+		}
+		from, err := utils.PrivatePath(file)
+		if err != nil {
+			return err
+		}
+		file.Path = to
+		toPrivate, err := utils.PrivatePath(file)
+		if err != nil {
+			return err
+		}
+		if toPrivate != from {
+			privateMoves = append(privateMoves, [2]utils.RepoRelativePath{from, toPrivate})
+		}
+	}
+
+	moved := false
+	move := func(path string) (string, bool) {
+		if strings.HasPrefix(path, sourcePrefix) {
+			moved = true
+			return destinationPrefix + strings.TrimPrefix(path, sourcePrefix), true
+		}
+		return "", false
+	}
+
+	ignoreChanges, err := moveEntries(&fileList, move)
+	if err != nil {
+		return err
+	}
+	if !moved {
+		return fmt.Errorf("%q contains no tracked files", source)
+	}
+
+	err = ignoreChanges.addNew()
+	if err != nil {
+		return err
+	}
+
+	err = moveInWorkTree(source, destination)
+	if err != nil {
+		return err
+	}
+
+	// Private files next to the originals have already been moved
+	for _, privateMove := range privateMoves {
+		err = moveInWorkTree(privateMove[0], privateMove[1])
+		if err != nil {
+			return err
+		}
+	}
+
+	err = moveSyncState(move)
+	if err != nil {
+		return err
+	}
+
+	err = utils.StoreFileList(fileList)
+	if err != nil {
+		return err
+	}
+
+	return ignoreChanges.removeOld()
+}
+
+func moveFilePath(file utils.RepoRelativePath, sourcePrefix string, destinationPrefix string) (utils.RepoRelativePath, bool) {
+	path := filepath.ToSlash(file.Relative())
+	if !strings.HasPrefix(path, sourcePrefix) {
+		return "", false
+	}
+	return utils.RepoRelativePath(filepath.FromSlash(destinationPrefix + strings.TrimPrefix(path, sourcePrefix))), true
+}
+
+func ensureNotExisting(file utils.RepoRelativePath) error {
+	absolute, err := utils.RepoAbsolute(file)
+	if err != nil {
+		return err
+	}
+	exists, err := utils.Exists(absolute)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("%q already exists", file)
+	}
+	return nil
+}
+
+// ignoreChanges are the ignore patterns to add and remove when moving
+// files. New patterns are added before moving, and old patterns removed
+// after, so that files are ignored at both paths if a move fails midway.
+type ignoreChanges struct {
+	add    []string
+	remove []string
+}
+
+func (changes ignoreChanges) addNew() error {
+	for _, pattern := range changes.add {
+		err := addIgnorePattern(pattern)
+		if err != nil {
+			return err
+		}
+	}
+	if len(changes.add) != 0 {
+		return moveIgnoreNegationLast()
+	}
+	return nil
+}
+
+func (changes ignoreChanges) removeOld() error {
+	for _, pattern := range changes.remove {
+		err := removeIgnorePattern(pattern)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveEntries moves file and pattern entries in the file list, as reported
+// by the given move function, and returns the ignore patterns to change.
+// Files matching patterns that no longer match after the move are
+// tracked by path instead.
+func moveEntries(fileList *utils.FileList, move func(path string) (string, bool)) (ignoreChanges, error) {
+	var changes ignoreChanges
+
+	for i := range fileList.Files {
+		file := &fileList.Files[i]
+		if to, moved := move(filepath.ToSlash(file.Path.Relative())); moved {
+			toPath := utils.RepoRelativePath(filepath.FromSlash(to))
+			changes.remove = append(changes.remove, file.Path.Relative())
+			changes.add = append(changes.add, toPath.Relative())
+			file.Path = toPath
+		}
+	}
+
+	var movedPatternFiles []utils.SecureFile
+
+	for i := range fileList.Patterns {
+		pattern := &fileList.Patterns[i]
+		if to, moved := move(pattern.Pattern); moved {
+			changes.remove = append(changes.remove, pattern.Pattern)
+			changes.add = append(changes.add, to)
+			pattern.Pattern = to
+		}
+
+		var remaining []utils.SecureFile
+		for _, file := range pattern.Files {
+			if to, moved := move(filepath.ToSlash(file.Path.Relative())); moved {
+				file.Path = utils.RepoRelativePath(filepath.FromSlash(to))
+				movedPatternFiles = append(movedPatternFiles, file)
+			} else {
+				remaining = append(remaining, file)
+			}
+		}
+		pattern.Files = remaining
+	}
+
+	for _, file := range movedPatternFiles {
+		if fileList.Covers(file.Path) {
+			err := fileList.UpdateFile(file)
+			if err != nil {
+				return ignoreChanges{}, err
+			}
+			continue
+		}
+		fileList.Files = append(fileList.Files, file)
+		changes.add = append(changes.add, file.Path.Relative())
+	}
+
+	return changes, nil
+}
+
+// moveSyncState moves the local sync records of moved files.
+func moveSyncState(move func(path string) (string, bool)) error {
+	state, err := utils.LoadSyncState()
+	if err != nil {
+		return err
+	}
+	for i := range state.Files {
+		file := &state.Files[i]
+		if to, moved := move(filepath.ToSlash(file.Path.Relative())); moved {
+			file.Path = utils.RepoRelativePath(filepath.FromSlash(to))
+		}
+	}
+	return utils.StoreSyncState(state)
+}
+
+// moveInWorkTree moves a file or directory, using git if it contains
+// files tracked by git, to keep the move visible as a rename.
+// Missing files are skipped.
+func moveInWorkTree(from utils.RepoRelativePath, to utils.RepoRelativePath) error {
+	fromAbsolute, err := utils.RepoAbsolute(from)
+	if err != nil {
+		return err
+	}
+	toAbsolute, err := utils.RepoAbsolute(to)
+	if err != nil {
+		return err
+	}
+
+	exists, err := utils.Exists(fromAbsolute)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+
+	err = os.MkdirAll(filepath.Dir(toAbsolute.Absolute()), 0770)
+	if err != nil {
+		return err
+	}
+
+	trackedFiles, err := utils.GitListTrackedFiles()
+	if err != nil {
+		return err
+	}
+	for _, file := range trackedFiles {
+		if file == from || strings.HasPrefix(file.Relative(), from.Relative()+string(filepath.Separator)) {
+			return utils.GitMove(from, to)
+		}
+	}
+
+	return os.Rename(fromAbsolute.Absolute(), toAbsolute.Absolute())
+}
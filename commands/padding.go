@@ -0,0 +1,82 @@
+package commands
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/erkkah/git-private/utils"
+)
+
+// Padding sets the padding scheme of the repo, and re-encrypts
+// all private files using it.
+func Padding(args []string, usage func()) error {
+	var config struct {
+		KeyFromFile string
+	}
+
+	flags := flag.NewFlagSet("padding <none|pow2|buckets>", flag.ExitOnError)
+	flags.StringVar(&config.KeyFromFile, "keyfile", "", "Load private key from `file`")
+	flags.Usage = usage
+
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		flags.Parse(args[1:])
+	} else {
+		return fmt.Errorf("no padding specified, expected <none|pow2|buckets>")
+	}
+
+	err := utils.EnsureInitialized()
+	if err != nil {
+		return err
+	}
+
+	padding, err := utils.ParsePadding(args[0])
+	if err != nil {
+		return err
+	}
+
+	settings, err := utils.LoadSettings()
+	if err != nil {
+		return err
+	}
+	if settings.Padding == padding {
+		return fmt.Errorf("padding already set to %q", args[0])
+	}
+
+	identity, err := loadPrivateKey(config.KeyFromFile)
+	if err != nil {
+		return err
+	}
+
+	err = unlockFileList(identity)
+	if err != nil {
+		return err
+	}
+
+	fileList, err := utils.LoadFileList()
+	if err != nil {
+		return err
+	}
+	if len(fileList.AllFiles()) == 0 {
+		settings.Padding = padding
+		return utils.StoreSettings(settings)
+	}
+
+	identities, err := fileIdentities(identity)
+	if err != nil {
+		return err
+	}
+	recipients, recipientsHash, err := fileRecipients(identity)
+	if err != nil {
+		return err
+	}
+
+	settings.Padding = padding
+	err = utils.StoreSettings(settings)
+	if err != nil {
+		return err
+	}
+
+	// Files are padded as they are encrypted again
+	return reEncryptPrivateFiles(identities, recipients, recipientsHash)
+}
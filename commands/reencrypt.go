@@ -0,0 +1,99 @@
+package commands
+
+import (
+	"flag"
+	"fmt"
+
+	"filippo.io/age"
+
+	"github.com/erkkah/git-private/utils"
+)
+
+// Reencrypt encrypts private files to the current keys, if they were
+// hidden using other keys, for example on another branch before merging.
+// The hash key, and the data key in envelope mode, are wrapped to the current keys.
+func Reencrypt(args []string, usage func()) error {
+	var config struct {
+		KeyFromFile string
+	}
+
+	flags := flag.NewFlagSet("reencrypt", flag.ExitOnError)
+	flags.StringVar(&config.KeyFromFile, "keyfile", "", "Load private key from `file`")
+	flags.Usage = usage
+	flags.Parse(args)
+
+	err := utils.EnsureInitialized()
+	if err != nil {
+		return err
+	}
+
+	identity, err := loadPrivateKey(config.KeyFromFile)
+	if err != nil {
+		return err
+	}
+
+	err = unlockFileList(identity)
+	if err != nil {
+		return err
+	}
+
+	err = rewrapHashKey(identity)
+	if err != nil {
+		return err
+	}
+
+	settings, err := utils.LoadSettings()
+	if err != nil {
+		return err
+	}
+	if settings.Envelope {
+		err = rewrapDataKey(identity)
+		if err != nil {
+			return err
+		}
+	}
+
+	count, err := reEncryptStaleFiles(identity)
+	if err != nil {
+		return err
+	}
+
+	if settings.Envelope {
+		// No files are left encrypted to replaced data keys
+		err = dropPreviousDataKeys(identity)
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("%v file%s encrypted again\n", count, pluralSuffix(count))
+	return nil
+}
+
+// reEncryptStaleFiles encrypts private files hidden using other keys
+// than the current ones to the current keys, and returns their number.
+func reEncryptStaleFiles(identity age.Identity) (int, error) {
+	identities, err := fileIdentities(identity)
+	if err != nil {
+		return 0, err
+	}
+	recipients, recipientsHash, err := fileRecipients(identity)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	err = rewritePrivateFiles(func(file utils.SecureFile, encrypted []byte) ([]byte, error) {
+		if file.RecipientsHash == recipientsHash {
+			return encrypted, nil
+		}
+		decrypted, err := decryptPrivateData(file, encrypted, identities...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt %q: %w", file.Path, err)
+		}
+		count++
+		return encryptPrivateData(file, decrypted, recipients, nil, nil)
+	}, recipientsHash)
+
+	return count, err
+}
@@ -0,0 +1,363 @@
+package commands
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"filippo.io/age"
+
+	"github.com/erkkah/git-private/utils"
+)
+
+// Relayout moves the private files of a repo to another layout,
+// without re-encrypting them.
+func Relayout(args []string, usage func()) error {
+	var config struct {
+		KeyFromFile string
+	}
+
+	flags := flag.NewFlagSet("relayout <sibling|mirror|blobs>", flag.ExitOnError)
+	flags.StringVar(&config.KeyFromFile, "keyfile", "", "Load private key from `file`")
+	flags.Usage = usage
+
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		flags.Parse(args[1:])
+	} else {
+		return fmt.Errorf("no layout specified, expected <sibling|mirror|blobs>")
+	}
+
+	err := utils.EnsureInitialized()
+	if err != nil {
+		return err
+	}
+
+	layout, err := parseLayout(args[0])
+	if err != nil {
+		return err
+	}
+
+	settings, err := utils.LoadSettings()
+	if err != nil {
+		return err
+	}
+	if settings.Layout == layout {
+		return fmt.Errorf("already using the %s layout", layoutName(layout))
+	}
+
+	// The blob layout needs the hash key to encrypt the file index
+	var identity age.Identity
+	if config.KeyFromFile != "" || layout == utils.BlobLayout {
+		identity, err = loadPrivateKey(config.KeyFromFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = unlockFileList(identity)
+	if err != nil {
+		return err
+	}
+
+	if layout == utils.BlobLayout {
+		err = migrateFileList(identity)
+		if err != nil {
+			return err
+		}
+	}
+
+	return relayout(settings, layout)
+}
+
+func parseLayout(name string) (utils.Layout, error) {
+	switch name {
+	case "", "sibling":
+		return utils.SiblingLayout, nil
+	case string(utils.MirrorLayout), string(utils.BlobLayout):
+		return utils.Layout(name), nil
+	default:
+		return "", fmt.Errorf("unknown layout %q, expected <sibling|mirror|blobs>", name)
+	}
+}
+
+func layoutName(layout utils.Layout) string {
+	if layout == utils.SiblingLayout {
+		return "sibling"
+	}
+	return string(layout)
+}
+
+// relayout moves all private files, and stores the file list for the new
+// layout, before switching layouts in the settings. If that fails, the
+// moved files are moved back. State of the previous layout is removed last.
+func relayout(settings utils.Settings, layout utils.Layout) error {
+	fileList, err := utils.LoadFileList()
+	if err != nil {
+		return err
+	}
+
+	previous := settings.Layout
+
+	var moved [][2]utils.RepoRelativePath
+	err = func() error {
+		for _, file := range fileList.AllFiles() {
+			from, err := previous.PrivatePath(file)
+			if err != nil {
+				return err
+			}
+
+			file.Blob = ""
+			if layout == utils.BlobLayout {
+				file.Blob, err = utils.NewBlobName()
+				if err != nil {
+					return err
+				}
+			}
+
+			to, err := layout.PrivatePath(file)
+			if err != nil {
+				return err
+			}
+
+			err = moveInWorkTree(from, to)
+			if err != nil {
+				return err
+			}
+			moved = append(moved, [2]utils.RepoRelativePath{from, to})
+
+			err = fileList.UpdateFile(file)
+			if err != nil {
+				return err
+			}
+		}
+
+		return storeFileListFor(layout, fileList)
+	}()
+	if err != nil {
+		for i := len(moved) - 1; i >= 0; i-- {
+			if moveErr := moveInWorkTree(moved[i][1], moved[i][0]); moveErr != nil {
+				return fmt.Errorf("%v, and failed to move %q back: %v", err, moved[i][1], moveErr)
+			}
+		}
+		return err
+	}
+
+	settings.Layout = layout
+	err = utils.StoreSettings(settings)
+	if err != nil {
+		return err
+	}
+
+	if (previous == utils.BlobLayout) != (layout == utils.BlobLayout) {
+		err = moveIgnorePatterns(fileList, layout == utils.BlobLayout)
+		if err != nil {
+			return err
+		}
+	}
+
+	negation, err := privateFilesIgnoreNegation(previous)
+	if err != nil {
+		return err
+	}
+	if negation != "" {
+		err = utils.GitRemoveIgnorePattern(negation)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = moveIgnoreNegationLast()
+	if err != nil {
+		return err
+	}
+
+	// The file list is kept in the index in the blob layout only
+	var replacedListFile utils.AbsolutePath
+	switch {
+	case previous == utils.BlobLayout:
+		replacedListFile, err = utils.IndexFile()
+	case layout == utils.BlobLayout:
+		replacedListFile, err = utils.PathsFile()
+	}
+	if err != nil {
+		return err
+	}
+	if replacedListFile != "" {
+		err = os.Remove(replacedListFile.Absolute())
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	previousStore, err := previous.StoreDir()
+	if err != nil {
+		return err
+	}
+	if previousStore != "" {
+		err = removeEmptyDirectories(previousStore)
+		if err != nil {
+			return err
+		}
+	}
+
+	return updatePrivateFileAttributes(previousStore, replacedListFile)
+}
+
+// storeFileListFor stores the file list where the given layout keeps it.
+func storeFileListFor(layout utils.Layout, fileList utils.FileList) error {
+	if layout == utils.BlobLayout {
+		file, err := utils.IndexFile()
+		if err != nil {
+			return err
+		}
+		return utils.StoreFileIndexTo(fileList, file)
+	}
+
+	file, err := utils.PathsFile()
+	if err != nil {
+		return err
+	}
+	return utils.StoreFileListTo(fileList, file)
+}
+
+// moveIgnorePatterns moves the ignore patterns of tracked files between
+// .gitignore and the local exclude file.
+func moveIgnorePatterns(fileList utils.FileList, toLocal bool) error {
+	var patterns []string
+	for _, file := range fileList.Files {
+		patterns = append(patterns, file.Path.Relative())
+	}
+	for _, pattern := range fileList.Patterns {
+		patterns = append(patterns, pattern.Pattern)
+	}
+
+	remove, add := utils.GitRemoveIgnorePattern, utils.GitAddLocalIgnorePattern
+	if !toLocal {
+		remove, add = utils.GitRemoveLocalIgnorePattern, utils.GitAddIgnorePattern
+	}
+
+	// Adding first, to keep files ignored if removing fails
+	for _, pattern := range patterns {
+		err := add(pattern)
+		if err != nil {
+			return err
+		}
+		err = remove(pattern)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !toLocal {
+		// Revealed files matching patterns are excluded one by one
+		for _, file := range fileList.AllFiles() {
+			err := utils.GitRemoveLocalIgnorePattern(file.Path.Relative())
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// removeEmptyDirectories removes the given directory, and all directories
+// below it that are left empty.
+func removeEmptyDirectories(dir utils.RepoRelativePath) error {
+	absolute, err := utils.RepoAbsolute(dir)
+	if err != nil {
+		return err
+	}
+
+	var dirs []string
+	err = filepath.Walk(absolute.Absolute(), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Deepest first, removing non-empty directories fails
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	for _, dir := range dirs {
+		os.Remove(dir)
+	}
+	return nil
+}
+
+// updatePrivateFileAttributes assigns installed diff and merge drivers
+// to private files in the current layout, and moves the merge driver
+// to the new file list. Attributes of the previous store directory and
+// file list are removed.
+func updatePrivateFileAttributes(previousStore utils.RepoRelativePath, replacedListFile utils.AbsolutePath) error {
+	patterns, err := utils.PrivateFilePatterns()
+	if err != nil {
+		return err
+	}
+
+	var stalePatterns []string
+	if previousStore != "" {
+		stalePatterns = append(stalePatterns, utils.StoreFilePattern(previousStore))
+	}
+
+	var replacedListPatterns []string
+	if replacedListFile != "" {
+		replacedList, err := utils.RepoRelative(replacedListFile)
+		if err != nil {
+			return err
+		}
+		replacedListPatterns = append(replacedListPatterns, filepath.ToSlash(replacedList.Relative()))
+	}
+
+	drivers := map[string]string{
+		fmt.Sprintf("diff.%s.textconv", filterName): "diff=" + filterName,
+		fmt.Sprintf("merge.%s.driver", filterName):  "merge=" + filterName,
+	}
+
+	for key, attribute := range drivers {
+		_, installed, err := utils.GitGetConfig(key)
+		if err != nil {
+			return err
+		}
+		if !installed {
+			continue
+		}
+
+		added, removed := patterns, stalePatterns
+		if attribute == "merge="+filterName {
+			stateFiles, err := stateFilePaths()
+			if err != nil {
+				return err
+			}
+			added = append(append([]string{}, patterns...), stateFiles...)
+			removed = append(append([]string{}, stalePatterns...), replacedListPatterns...)
+		}
+
+		for _, pattern := range removed {
+			err = utils.GitRemoveAttributes(pattern, attribute)
+			if err != nil {
+				return err
+			}
+		}
+		for _, pattern := range added {
+			err = utils.GitAddAttributes(pattern, attribute)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -18,9 +18,24 @@ func Remove(files []string, _ func()) error {
 		return fmt.Errorf("no files to remove")
 	}
 
+	err = unlockFileList(nil)
+	if err != nil {
+		return err
+	}
+
 	var filesToRemove []utils.RepoRelativePath
+	var patternsToRemove []string
 
 	for _, file := range files {
+		if utils.IsPattern(file) {
+			pattern, err := repoRelativePattern(file)
+			if err != nil {
+				return err
+			}
+			patternsToRemove = append(patternsToRemove, pattern)
+			continue
+		}
+
 		absolute, err := filepath.Abs(file)
 		if err != nil {
 			return err
@@ -29,6 +44,12 @@ func Remove(files []string, _ func()) error {
 		if err != nil {
 			return err
 		}
+
+		if info, err := os.Stat(absolute); err == nil && info.IsDir() {
+			patternsToRemove = append(patternsToRemove, filepath.ToSlash(repoRelative.Relative())+"/**")
+			continue
+		}
+
 		filesToRemove = append(filesToRemove, repoRelative)
 	}
 
@@ -37,12 +58,17 @@ func Remove(files []string, _ func()) error {
 		return err
 	}
 
+	err = removePatterns(patternsToRemove)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
 func removeFiles(files []utils.RepoRelativePath) error {
 	for _, file := range files {
-		err := utils.GitRemoveIgnorePattern(file.Relative())
+		err := removeIgnorePattern(file.Relative())
 		if err != nil {
 			return err
 		}
@@ -60,19 +86,92 @@ func removeFile(file utils.RepoRelativePath) error {
 		return err
 	}
 
-	var updatedList utils.FileList
+	if !hasFile(fileList, file) {
+		for _, pattern := range fileList.Patterns {
+			if utils.MatchPattern(pattern.Pattern, file) {
+				return fmt.Errorf("file %q is tracked by pattern %q, remove the pattern instead", file, pattern.Pattern)
+			}
+		}
+	}
+
+	removed := utils.SecureFile{Path: file}
+	var updatedFiles []utils.SecureFile
 	for _, fileEntry := range fileList.Files {
 		if fileEntry.Path != file {
-			updatedList.Files = append(updatedList.Files, fileEntry)
+			updatedFiles = append(updatedFiles, fileEntry)
+		} else {
+			removed = fileEntry
+		}
+	}
+	fileList.Files = updatedFiles
+
+	err = utils.StoreFileList(fileList)
+	if err != nil {
+		return err
+	}
+
+	return removePrivateFile(removed)
+}
+
+func removePatterns(patterns []string) error {
+	if len(patterns) == 0 {
+		return nil
+	}
+
+	fileList, err := utils.LoadFileList()
+	if err != nil {
+		return err
+	}
+
+	for _, pattern := range patterns {
+		if !hasPattern(fileList, pattern) {
+			return fmt.Errorf("pattern %q is not tracked", pattern)
+		}
+
+		err = removeIgnorePattern(pattern)
+		if err != nil {
+			return err
+		}
+
+		var updatedPatterns []utils.SecurePattern
+		for _, patternEntry := range fileList.Patterns {
+			if patternEntry.Pattern != pattern {
+				updatedPatterns = append(updatedPatterns, patternEntry)
+				continue
+			}
+			for _, file := range patternEntry.Files {
+				err = removePrivateFile(file)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		fileList.Patterns = updatedPatterns
+	}
+
+	return utils.StoreFileList(fileList)
+}
+
+func hasFile(fileList utils.FileList, file utils.RepoRelativePath) bool {
+	for _, fileEntry := range fileList.Files {
+		if fileEntry.Path == file {
+			return true
 		}
 	}
+	return false
+}
 
-	err = utils.StoreFileList(updatedList)
+func removePrivateFile(file utils.SecureFile) error {
+	err := utils.ForgetSynced(file.Path)
 	if err != nil {
 		return err
 	}
 
-	privateFile, err := utils.RepoAbsolute(file + utils.PrivateExtension)
+	privatePath, err := utils.PrivatePath(file)
+	if err != nil {
+		return err
+	}
+	privateFile, err := utils.RepoAbsolute(privatePath)
 	if err != nil {
 		return err
 	}
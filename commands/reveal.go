@@ -1,7 +1,6 @@
 package commands
 
 import (
-	"bytes"
 	"errors"
 	"flag"
 	"fmt"
@@ -33,6 +32,16 @@ func Reveal(args []string, usage func()) error {
 		return err
 	}
 
+	identity, err := loadPrivateKey(config.KeyFromFile)
+	if err != nil {
+		return err
+	}
+
+	err = unlockFileList(identity)
+	if err != nil {
+		return err
+	}
+
 	var filesToReveal []utils.SecureFile
 	fileList, err := utils.LoadFileList()
 	if err != nil {
@@ -45,7 +54,7 @@ func Reveal(args []string, usage func()) error {
 			if err != nil {
 				return fmt.Errorf("failed to resolve path to %q", arg)
 			}
-			file, err := findFile(utils.AbsolutePath(absolute), fileList.Files)
+			file, err := findFile(utils.AbsolutePath(absolute), fileList.AllFiles())
 			if err == errNotFound {
 				return fmt.Errorf("file %q is not hidden", arg)
 			}
@@ -55,10 +64,10 @@ func Reveal(args []string, usage func()) error {
 			filesToReveal = append(filesToReveal, file)
 		}
 	} else {
-		filesToReveal = fileList.Files
+		filesToReveal = fileList.AllFiles()
 	}
 
-	identity, err := loadPrivateKey(config.KeyFromFile)
+	hasher, err := loadFileHasher(fileList, identity)
 	if err != nil {
 		return err
 	}
@@ -67,7 +76,7 @@ func Reveal(args []string, usage func()) error {
 	inSync := 0
 
 	for _, file := range filesToReveal {
-		status, err := getFileStatus(file)
+		status, err := getFileStatus(hasher, file)
 		if err != nil {
 			return fmt.Errorf("failed to get file status: %w", err)
 		}
@@ -77,15 +86,15 @@ func Reveal(args []string, usage func()) error {
 			continue
 		case hiddenPrivateMissing:
 			return fmt.Errorf("cannot reveal, private version of %q is missing", file.Path)
-		case hiddenModified:
+		case hiddenModified, hiddenConflict:
 			if !config.Overwrite {
 				return fmt.Errorf("will not overwrite existing file %q without 'force' flag", file.Path)
 			}
 		case notHidden:
 			return fmt.Errorf("file %q is not hidden", file.Path)
-		case hiddenNotRevealed:
+		case hiddenNotRevealed, hiddenRemoteUpdated:
 		}
-		err = decrypt(file.Path, config.Clean, identity)
+		err = decrypt(file, config.Clean, identity)
 		if err != nil {
 			return fmt.Errorf("reveal failed: %w", err)
 		}
@@ -125,32 +134,53 @@ func findFile(path utils.AbsolutePath, files []utils.SecureFile) (utils.SecureFi
 	return utils.SecureFile{}, errNotFound
 }
 
-func decrypt(file utils.RepoRelativePath, clean bool, identity age.Identity) error {
+func decrypt(file utils.SecureFile, clean bool, identity age.Identity) error {
 	root, err := utils.GetGitRootPath()
 	if err != nil {
 		return err
 	}
 
-	fullPath := root.Join(file)
-	privatePath := fullPath + utils.PrivateExtension
+	privateFile, err := utils.PrivatePath(file)
+	if err != nil {
+		return err
+	}
+
+	fullPath := root.Join(file.Path)
+	privatePath := root.Join(privateFile)
+
+	identities, err := fileIdentities(identity)
+	if err != nil {
+		return err
+	}
 
-	encrypted, err := privatePath.Open()
+	encrypted, err := os.ReadFile(privatePath.Absolute())
 	if err != nil {
 		return err
 	}
 
-	decryptedReader, err := age.Decrypt(encrypted, identity)
+	decrypted, err := decryptPrivateData(file, encrypted, identities...)
 	if err != nil {
 		return err
 	}
 
-	var buf bytes.Buffer
-	_, err = io.Copy(&buf, decryptedReader)
+	if file.Blob != "" {
+		// Paths are not listed in .gitignore in the blob layout
+		err = utils.GitAddLocalIgnorePattern(file.Path.Relative())
+		if err != nil {
+			return err
+		}
+	}
+
+	err = os.MkdirAll(filepath.Dir(fullPath.Absolute()), 0770)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(fullPath.Absolute(), decrypted, 0660)
 	if err != nil {
 		return err
 	}
 
-	err = os.WriteFile(fullPath.Absolute(), buf.Bytes(), 0660)
+	err = recordRevealed(file, decrypted, identity)
 	if err != nil {
 		return err
 	}
@@ -164,3 +194,49 @@ func decrypt(file utils.RepoRelativePath, clean bool, identity age.Identity) err
 
 	return nil
 }
+
+// decryptData decrypts a private file, armored or binary,
+// undoing any compression and padding.
+func decryptData(encrypted io.Reader, identities ...age.Identity) ([]byte, error) {
+	decryptedReader, err := decryptReader(encrypted, identities...)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(decryptedReader)
+}
+
+// decryptReader returns a reader of the plain text of an age file,
+// decrypted as it is read.
+func decryptReader(encrypted io.Reader, identities ...age.Identity) (io.Reader, error) {
+	decryptedReader, err := age.Decrypt(dearmorReader(encrypted), identities...)
+	if err != nil {
+		return nil, err
+	}
+	return utils.NewPayloadReader(decryptedReader)
+}
+
+// recordRevealed records that a revealed file is in sync with its private file.
+func recordRevealed(file utils.SecureFile, decrypted []byte, identity age.Identity) error {
+	fileList, err := utils.LoadFileList()
+	if err != nil {
+		return err
+	}
+	hasher, err := loadFileHasher(fileList, identity)
+	if err != nil {
+		return err
+	}
+	hash := hasher.Hash(decrypted)
+	privatePath, err := utils.PrivatePath(file)
+	if err != nil {
+		return err
+	}
+	privateHash, err := utils.GetFileHash(privatePath)
+	if err != nil {
+		return err
+	}
+	return utils.RecordSynced(utils.SecureFile{
+		Path:        file.Path,
+		Hash:        hash,
+		PrivateHash: privateHash,
+	})
+}
@@ -0,0 +1,279 @@
+package commands
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"unicode"
+
+	"github.com/erkkah/git-private/utils"
+)
+
+const maxScannedFileSize = 1024 * 1024
+
+var builtinScanRules = []utils.ScanRule{
+	{Name: "private key", Content: `-----BEGIN ([A-Z0-9]+ )*PRIVATE KEY( BLOCK)?-----`},
+	{Name: "age secret key", Content: `AGE-SECRET-KEY-1[0-9A-Z]{58}`},
+	{Name: "AWS access key", Content: `\b(AKIA|ASIA|AGPA|AIDA|AROA|ANPA|ANVA|AIPA)[A-Z0-9]{16}\b`},
+	{Name: "dotenv file", Path: ".env"},
+	{Name: "dotenv file", Path: ".env.*"},
+	{Name: "dotenv file", Path: "*.env"},
+}
+
+// Files that are expected to contain high entropy strings, or
+// examples of secrets.
+var builtinScanIgnores = []string{
+	"go.sum",
+	"*.lock",
+	"package-lock.json",
+	"pnpm-lock.yaml",
+	".env.example",
+	".env.sample",
+	".env.template",
+}
+
+var highEntropyCandidate = regexp.MustCompile(`[A-Za-z0-9+/=_-]{20,}`)
+
+type compiledScanRule struct {
+	name    string
+	path    string
+	content *regexp.Regexp
+}
+
+// Scan looks for files in the working tree that look like they
+// contain secrets, and are not private.
+func Scan(args []string, usage func()) error {
+	var config struct {
+		Add     bool
+		Untrack bool
+	}
+
+	flags := flag.NewFlagSet("scan", flag.ExitOnError)
+	flags.BoolVar(&config.Add, "add", false, "Add found files")
+	flags.BoolVar(&config.Untrack, "untrack", false, "Remove added files that are already tracked by git from the index")
+	flags.Usage = usage
+	flags.Parse(args)
+
+	err := utils.EnsureInitialized()
+	if err != nil {
+		return err
+	}
+
+	err = unlockFileList(nil)
+	if err != nil {
+		return err
+	}
+
+	userRules, err := utils.LoadScanRules()
+	if err != nil {
+		return fmt.Errorf("failed to load scan rules: %w", err)
+	}
+
+	var rules []compiledScanRule
+	for _, rule := range append(builtinScanRules, userRules.Rules...) {
+		compiled := compiledScanRule{
+			name: rule.Name,
+			path: rule.Path,
+		}
+		if rule.Content != "" {
+			compiled.content, err = regexp.Compile(rule.Content)
+			if err != nil {
+				return fmt.Errorf("invalid scan rule %q: %w", rule.Name, err)
+			}
+		}
+		rules = append(rules, compiled)
+	}
+	ignores := append(builtinScanIgnores, userRules.Ignore...)
+
+	candidates, err := scanCandidates(ignores)
+	if err != nil {
+		return err
+	}
+
+	var found []utils.RepoRelativePath
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 4, ' ', 0)
+
+	for _, file := range candidates {
+		matches, err := scanFile(file, rules)
+		if err != nil {
+			return err
+		}
+		if len(matches) != 0 {
+			found = append(found, file)
+			fmt.Fprintf(w, "%s\t[%s]\n", file, strings.Join(matches, ", "))
+		}
+	}
+	w.Flush()
+
+	if len(found) == 0 {
+		fmt.Println("No likely secrets found")
+		return nil
+	}
+
+	if !config.Add {
+		return fmt.Errorf("%d likely secret file%s found, use 'scan -add' to add", len(found), pluralSuffix(len(found)))
+	}
+
+	root, err := utils.GetGitRootPath()
+	if err != nil {
+		return err
+	}
+
+	var addArgs []string
+	if config.Untrack {
+		addArgs = append(addArgs, "-untrack")
+	}
+	for _, file := range found {
+		addArgs = append(addArgs, root.Join(file).Absolute())
+	}
+
+	return Add(addArgs, usage)
+}
+
+// scanCandidates lists files in the working tree that are not
+// already private, or part of the git-private state.
+func scanCandidates(ignores []string) ([]utils.RepoRelativePath, error) {
+	files, err := utils.GitListFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	fileList, err := utils.LoadFileList()
+	if err != nil {
+		return nil, err
+	}
+	stateDir, err := utils.StateDir()
+	if err != nil {
+		return nil, err
+	}
+	stateDirRelative, err := utils.RepoRelative(stateDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []utils.RepoRelativePath
+
+nextFile:
+	for _, file := range files {
+		if fileList.Covers(file) ||
+			strings.HasSuffix(file.Relative(), utils.PrivateExtension) ||
+			strings.HasPrefix(file.Relative(), stateDirRelative.Relative()+"/") {
+			continue
+		}
+		for _, ignore := range ignores {
+			if utils.MatchPattern(ignore, file) {
+				continue nextFile
+			}
+		}
+		candidates = append(candidates, file)
+	}
+
+	return candidates, nil
+}
+
+func scanFile(file utils.RepoRelativePath, rules []compiledScanRule) ([]string, error) {
+	absolute, err := utils.RepoAbsolute(file)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(absolute.Absolute())
+	if err != nil {
+		// Deleted, but not committed
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if !info.Mode().IsRegular() || info.Size() > maxScannedFileSize {
+		return nil, nil
+	}
+
+	contents, err := os.ReadFile(absolute.Absolute())
+	if err != nil {
+		return nil, err
+	}
+	isBinary := bytes.IndexByte(contents, 0) != -1
+
+	var matches []string
+	matched := map[string]bool{}
+	addMatch := func(name string) {
+		if !matched[name] {
+			matched[name] = true
+			matches = append(matches, name)
+		}
+	}
+
+	for _, rule := range rules {
+		if rule.path == "" && rule.content == nil {
+			continue
+		}
+		if rule.path != "" && !utils.MatchPattern(rule.path, file) {
+			continue
+		}
+		if rule.content != nil && (isBinary || !rule.content.Match(contents)) {
+			continue
+		}
+		addMatch(rule.name)
+	}
+
+	if !isBinary && hasHighEntropyString(contents) {
+		addMatch("high entropy string")
+	}
+
+	return matches, nil
+}
+
+// hasHighEntropyString looks for long strings of random looking
+// letters and digits, like API tokens and encoded keys.
+func hasHighEntropyString(contents []byte) bool {
+	for _, candidate := range highEntropyCandidate.FindAll(contents, -1) {
+		if bytes.IndexFunc(candidate, unicode.IsDigit) == -1 || bytes.IndexFunc(candidate, unicode.IsLetter) == -1 {
+			continue
+		}
+
+		// Random strings get close to the maximum entropy
+		// for their length and alphabet
+		alphabetSize := 64.0
+		if isHex(candidate) {
+			alphabetSize = 16
+		}
+		maxEntropy := math.Log2(math.Min(float64(len(candidate)), alphabetSize))
+		if shannonEntropy(candidate) > 0.9*maxEntropy {
+			return true
+		}
+	}
+	return false
+}
+
+func isHex(data []byte) bool {
+	for _, c := range data {
+		isHexDigit := (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+		if !isHexDigit {
+			return false
+		}
+	}
+	return true
+}
+
+func shannonEntropy(data []byte) float64 {
+	var counts [256]int
+	for _, c := range data {
+		counts[c]++
+	}
+
+	entropy := 0.0
+	for _, count := range counts {
+		if count == 0 {
+			continue
+		}
+		p := float64(count) / float64(len(data))
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
@@ -1,14 +1,26 @@
 package commands
 
 import (
+	"flag"
 	"fmt"
 	"os"
 	"text/tabwriter"
 
+	"filippo.io/age"
+
 	"github.com/erkkah/git-private/utils"
 )
 
-func Status(_ []string, _ func()) error {
+func Status(args []string, usage func()) error {
+	var config struct {
+		KeyFromFile string
+	}
+
+	flags := flag.NewFlagSet("status", flag.ExitOnError)
+	flags.StringVar(&config.KeyFromFile, "keyfile", "", "Load private key from `file`, if needed to check file status")
+	flags.Usage = usage
+	flags.Parse(args)
+
 	stateDir, err := utils.StateDir()
 	if err != nil {
 		return err
@@ -21,24 +33,57 @@ func Status(_ []string, _ func()) error {
 		return fmt.Errorf("%s not initialized in repo", utils.ToolName)
 	}
 
-	files, err := utils.LoadFileList()
+	var identity age.Identity
+	if config.KeyFromFile != "" {
+		identity, err = loadPrivateKey(config.KeyFromFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = unlockFileList(identity)
+	if err != nil {
+		return err
+	}
+
+	fileList, err := utils.LoadFileList()
 	if err != nil {
 		return fmt.Errorf("failed to load file list: %w", err)
 	}
 
+	files, err := utils.ExpandFileList(fileList)
+	if err != nil {
+		return err
+	}
+
+	hasher, err := loadFileHasher(fileList, identity)
+	if err != nil {
+		return err
+	}
+
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 4, ' ', 0)
 
-	if len(files.Files) == 0 {
+	if len(files) == 0 && len(fileList.Patterns) == 0 {
 		fmt.Fprintln(w, "No private files")
 	}
-	for _, file := range files.Files {
-		status, err := getFileStatus(file)
+	for _, file := range files {
+		status, err := getFileStatus(hasher, file)
 		if err != nil {
 			return err
 		}
 
 		fmt.Fprintf(w, "%s\t[%s]\n", file.Path, status)
 	}
+
+nextPattern:
+	for _, pattern := range fileList.Patterns {
+		for _, file := range files {
+			if utils.MatchPattern(pattern.Pattern, file.Path) {
+				continue nextPattern
+			}
+		}
+		fmt.Fprintf(w, "%s\t[no matching files]\n", pattern.Pattern)
+	}
 	w.Flush()
 
 	return nil
@@ -50,8 +95,13 @@ func areFilesInSync() (bool, error) {
 		return false, err
 	}
 
-	for _, file := range files.Files {
-		status, err := getFileStatus(file)
+	hasher, err := loadFileHasher(files, nil)
+	if err != nil {
+		return false, err
+	}
+
+	for _, file := range files.AllFiles() {
+		status, err := getFileStatus(hasher, file)
 		if err != nil {
 			return false, err
 		}
@@ -64,43 +114,70 @@ func areFilesInSync() (bool, error) {
 	return true, nil
 }
 
-func getFileStatus(file utils.SecureFile) (statusCode, error) {
+func getFileStatus(hasher utils.FileHasher, file utils.SecureFile) (statusCode, error) {
 	root, err := utils.GetGitRootPath()
 	if err != nil {
 		return 0, err
 	}
 	fullPath := root.Join(file.Path)
 
-	var status statusCode
-
 	if file.Hash == "" {
-		status = notHidden
+		return notHidden, nil
+	}
+
+	privatePath, err := utils.PrivatePath(file)
+	if err != nil {
+		return 0, err
+	}
+	privateFile := root.Join(privatePath)
+	if exists, err := utils.Exists(privateFile); !exists || err != nil {
+		return hiddenPrivateMissing, err
+	}
+	if exists, err := utils.Exists(fullPath); !exists || err != nil {
+		return hiddenNotRevealed, err
+	}
+
+	hash, err := hasher.HashFile(file.Path)
+	if err != nil {
+		return 0, err
+	}
+	privateHash, err := utils.GetFileHash(privatePath)
+	if err != nil {
+		return 0, err
+	}
+
+	if hash == file.Hash && (file.PrivateHash == "" || privateHash == file.PrivateHash) {
+		return hiddenInSync, nil
+	}
+
+	// Compare to the versions last synced in this work tree, to tell
+	// local modifications from updated private files.
+	// Without a record, all differences are local modifications.
+	var locallyModified, remotelyUpdated bool
+
+	syncState, err := utils.LoadSyncState()
+	if err != nil {
+		return 0, err
+	}
+	if synced, found := syncState.FindFile(file.Path); found {
+		locallyModified = hash != synced.Hash
+		// Re-encrypting the same contents is not an update
+		reEncrypted := privateHash == file.PrivateHash && file.Hash == synced.Hash
+		remotelyUpdated = privateHash != synced.PrivateHash && !reEncrypted
 	} else {
-		privateFile := fullPath + utils.PrivateExtension
-		if exists, err := utils.Exists(privateFile); !exists || err != nil {
-			if err != nil {
-				return 0, err
-			}
-			status = hiddenPrivateMissing
-		} else if exists, err := utils.Exists(fullPath); !exists || err != nil {
-			if err != nil {
-				return 0, err
-			}
-			status = hiddenNotRevealed
-		} else {
-			hash, err := utils.GetFileHash(file.Path)
-			if err != nil {
-				return 0, err
-			}
-			if hash == file.Hash {
-				status = hiddenInSync
-			} else {
-				status = hiddenModified
-			}
-		}
+		locallyModified = hash != file.Hash
 	}
 
-	return status, nil
+	switch {
+	case locallyModified && remotelyUpdated:
+		return hiddenConflict, nil
+	case remotelyUpdated:
+		return hiddenRemoteUpdated, nil
+	case locallyModified || hash != file.Hash:
+		return hiddenModified, nil
+	default:
+		return hiddenInSync, nil
+	}
 }
 
 type statusCode int
@@ -111,6 +188,8 @@ const (
 	hiddenModified
 	hiddenNotRevealed
 	hiddenPrivateMissing
+	hiddenRemoteUpdated
+	hiddenConflict
 )
 
 func (code statusCode) String() string {
@@ -125,6 +204,10 @@ func (code statusCode) String() string {
 		return "hidden, not revealed"
 	case hiddenPrivateMissing:
 		return "WARNING: private file missing!"
+	case hiddenRemoteUpdated:
+		return "hidden, updated, needs reveal"
+	case hiddenConflict:
+		return "CONFLICT: modified and updated"
 	default:
 		return "unknown"
 	}
@@ -0,0 +1,238 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+
+	"filippo.io/age"
+
+	"github.com/erkkah/git-private/utils"
+)
+
+// Encrypted values in structured private files are written as
+// ENC[age:<base64 encoded age file>], quoted as a string in JSON.
+const sealedPrefix = "ENC[age:"
+const sealedSuffix = "]"
+
+// Sealed values are bound to their paths, to keep values from being
+// swapped between keys. The encrypted payload starts with this magic,
+// followed by the length of the path and the path.
+var valuePathMagic = []byte("\x01git-private-path")
+
+// sealedValue is an encrypted value of a structured private file,
+// together with its plain text.
+type sealedValue struct {
+	sealed []byte
+	plain  []byte
+}
+
+// encryptPrivateData encrypts the plain text of a file, as a whole or
+// value by value, depending on its format. Values of structured files
+// found unchanged in the given previous private file keep their
+// encrypted form, if it can be decrypted using the given identities.
+func encryptPrivateData(file utils.SecureFile, plain []byte, recipients []age.Recipient, previous []byte, identities []age.Identity) ([]byte, error) {
+	if file.Format == utils.WholeFile {
+		return encryptData(bytes.NewReader(plain), recipients)
+	}
+
+	reusable := map[string]sealedValue{}
+	if previous != nil && len(identities) != 0 && !isEncrypted(previous) {
+		// Values that cannot be decrypted are encrypted again
+		reusable, _ = sealedValues(file.Format, previous, identities)
+	}
+
+	settings, err := utils.LoadSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	return replaceValues(file.Format, plain, func(value utils.Value, raw []byte) ([]byte, error) {
+		if previous, found := reusable[value.Path]; found && bytes.Equal(previous.plain, raw) {
+			return previous.sealed, nil
+		}
+
+		var buf bytes.Buffer
+		encryptedWriter, err := age.Encrypt(&buf, recipients...)
+		if err != nil {
+			return nil, err
+		}
+		payload, err := utils.EncodePayload(raw, false, settings.Padding)
+		if err != nil {
+			return nil, err
+		}
+		_, err = encryptedWriter.Write(bindValuePath(value.Path, payload))
+		if err != nil {
+			return nil, err
+		}
+		err = encryptedWriter.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		sealed := sealedPrefix + base64.RawStdEncoding.EncodeToString(buf.Bytes()) + sealedSuffix
+		if file.Format == utils.JSONFormat {
+			sealed = `"` + sealed + `"`
+		}
+		return []byte(sealed), nil
+	})
+}
+
+// decryptPrivateData decrypts the contents of a private file.
+// Whole file encryption is recognized by content, so that files hidden
+// before their format was changed can still be revealed.
+func decryptPrivateData(file utils.SecureFile, encrypted []byte, identities ...age.Identity) ([]byte, error) {
+	if isEncrypted(encrypted) {
+		return decryptData(bytes.NewReader(encrypted), identities...)
+	}
+	if file.Format == utils.WholeFile {
+		return nil, fmt.Errorf("%q is not an age file", file.Path)
+	}
+
+	return replaceValues(file.Format, encrypted, func(value utils.Value, raw []byte) ([]byte, error) {
+		encoded, sealed := unsealValue(file.Format, raw)
+		if !sealed {
+			return raw, nil
+		}
+		plain, _, err := decryptValue(value.Path, encoded, identities)
+		return plain, err
+	})
+}
+
+// sealedValues returns the encrypted values of a structured private file
+// by path, with their plain text.
+func sealedValues(format utils.Format, encrypted []byte, identities []age.Identity) (map[string]sealedValue, error) {
+	values, err := format.Values(encrypted)
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string]sealedValue{}
+	for _, value := range values {
+		raw := encrypted[value.Start:value.End]
+		encoded, sealed := unsealValue(format, raw)
+		if !sealed {
+			continue
+		}
+		plain, bound, err := decryptValue(value.Path, encoded, identities)
+		if err != nil {
+			return nil, err
+		}
+		// Values sealed before binding to paths are encrypted again
+		if _, found := result[value.Path]; !found && bound {
+			result[value.Path] = sealedValue{sealed: raw, plain: plain}
+		}
+	}
+	return result, nil
+}
+
+// unsealedValues returns the paths of values in a structured private file
+// that are not encrypted.
+func unsealedValues(format utils.Format, data []byte) ([]string, error) {
+	values, err := format.Values(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, value := range values {
+		if _, sealed := unsealValue(format, data[value.Start:value.End]); !sealed {
+			paths = append(paths, value.Path)
+		}
+	}
+	return paths, nil
+}
+
+func unsealValue(format utils.Format, raw []byte) (string, bool) {
+	value := string(raw)
+	if format == utils.JSONFormat {
+		if len(value) < 2 || value[0] != '"' || value[len(value)-1] != '"' {
+			return "", false
+		}
+		value = value[1 : len(value)-1]
+	}
+	if !strings.HasPrefix(value, sealedPrefix) || !strings.HasSuffix(value, sealedSuffix) {
+		return "", false
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(value, sealedPrefix), sealedSuffix), true
+}
+
+// decryptValue decrypts a sealed value found at the given path.
+// Values bound to another path are rejected. Values sealed before binding
+// to paths are accepted, and reported as not bound.
+func decryptValue(path string, encoded string, identities []age.Identity) ([]byte, bool, error) {
+	encrypted, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid encrypted value: %w", err)
+	}
+	decryptedReader, err := age.Decrypt(bytes.NewReader(encrypted), identities...)
+	if err != nil {
+		return nil, false, err
+	}
+	payload, err := io.ReadAll(decryptedReader)
+	if err != nil {
+		return nil, false, err
+	}
+
+	bound := bytes.HasPrefix(payload, valuePathMagic)
+	if bound {
+		boundPath, rest, err := splitValuePath(payload)
+		if err != nil {
+			return nil, false, err
+		}
+		if boundPath != path {
+			return nil, false, fmt.Errorf("encrypted value belongs to %q", boundPath)
+		}
+		payload = rest
+	}
+
+	plain, err := utils.DecodePayload(payload)
+	return plain, bound, err
+}
+
+func bindValuePath(path string, payload []byte) []byte {
+	bound := append([]byte{}, valuePathMagic...)
+	bound = binary.BigEndian.AppendUint32(bound, uint32(len(path)))
+	bound = append(bound, path...)
+	return append(bound, payload...)
+}
+
+func splitValuePath(bound []byte) (string, []byte, error) {
+	rest := bound[len(valuePathMagic):]
+	if len(rest) < 4 {
+		return "", nil, fmt.Errorf("truncated encrypted value")
+	}
+	length := binary.BigEndian.Uint32(rest)
+	rest = rest[4:]
+	if uint64(len(rest)) < uint64(length) {
+		return "", nil, fmt.Errorf("truncated encrypted value")
+	}
+	return string(rest[:length]), rest[length:], nil
+}
+
+// replaceValues returns the data with each value of a structured file
+// replaced as returned by the replace function.
+func replaceValues(format utils.Format, data []byte, replace func(value utils.Value, raw []byte) ([]byte, error)) ([]byte, error) {
+	values, err := format.Values(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", format, err)
+	}
+
+	var result bytes.Buffer
+	offset := 0
+	for _, value := range values {
+		replaced, err := replace(value, data[value.Start:value.End])
+		if err != nil {
+			return nil, fmt.Errorf("value %q: %w", value.Path, err)
+		}
+		result.Write(data[offset:value.Start])
+		result.Write(replaced)
+		offset = value.End
+	}
+	result.Write(data[offset:])
+
+	return result.Bytes(), nil
+}
@@ -0,0 +1,128 @@
+package commands
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/erkkah/git-private/utils"
+)
+
+// Sync hides locally modified files and reveals files with updated
+// private files. Stops without changing anything if files were both
+// modified and updated.
+func Sync(args []string, usage func()) error {
+	var config struct {
+		KeyFromFile string
+	}
+
+	flags := flag.NewFlagSet("sync", flag.ExitOnError)
+	flags.StringVar(&config.KeyFromFile, "keyfile", "", "Load private key from `file`")
+	flags.Usage = usage
+	flags.Parse(args)
+
+	err := utils.EnsureInitialized()
+	if err != nil {
+		return err
+	}
+
+	identity, err := loadPrivateKey(config.KeyFromFile)
+	if err != nil {
+		return err
+	}
+
+	err = unlockFileList(identity)
+	if err != nil {
+		return err
+	}
+
+	err = migrateFileList(identity)
+	if err != nil {
+		return err
+	}
+
+	fileList, err := utils.LoadFileList()
+	if err != nil {
+		return err
+	}
+
+	files, err := utils.ExpandFileList(fileList)
+	if err != nil {
+		return err
+	}
+
+	hasher, err := loadFileHasher(fileList, identity)
+	if err != nil {
+		return err
+	}
+
+	var toHide []utils.RepoRelativePath
+	var toReveal []utils.SecureFile
+	var conflicts []string
+
+	for _, file := range files {
+		status, err := getFileStatus(hasher, file)
+		if err != nil {
+			return err
+		}
+
+		switch status {
+		case notHidden, hiddenModified:
+			toHide = append(toHide, file.Path)
+		case hiddenNotRevealed, hiddenRemoteUpdated:
+			toReveal = append(toReveal, file)
+		case hiddenConflict:
+			conflicts = append(conflicts, fmt.Sprintf("\t%s", file.Path))
+		case hiddenPrivateMissing:
+			absolute, err := utils.RepoAbsolute(file.Path)
+			if err != nil {
+				return err
+			}
+			exists, err := utils.Exists(absolute)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				return fmt.Errorf("both %q and its private file are missing", file.Path)
+			}
+			toHide = append(toHide, file.Path)
+		}
+	}
+
+	if len(conflicts) != 0 {
+		return fmt.Errorf("files were modified, and their private files were updated:\n%s\n"+
+			"use 'hide -force' to keep local changes, or 'reveal -force' to keep updates",
+			strings.Join(conflicts, "\n"))
+	}
+
+	if len(toHide) == 0 && len(toReveal) == 0 {
+		fmt.Println("All files in sync")
+		return nil
+	}
+
+	if fileList.RetainPatternFiles(files) {
+		err = utils.StoreFileList(fileList)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(toHide) != 0 {
+		err = hideFiles(identity, toHide, false, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, file := range toReveal {
+		err = decrypt(file, false, identity)
+		if err != nil {
+			return fmt.Errorf("failed to reveal %q: %w", file.Path, err)
+		}
+	}
+
+	fmt.Printf("%v file%s hidden, %v file%s revealed\n",
+		len(toHide), pluralSuffix(len(toHide)), len(toReveal), pluralSuffix(len(toReveal)))
+
+	return nil
+}
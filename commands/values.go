@@ -0,0 +1,286 @@
+package commands
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"filippo.io/age"
+
+	"github.com/erkkah/git-private/utils"
+)
+
+// Get prints a single value of a hidden structured file,
+// decrypted in memory, without revealing the file.
+func Get(args []string, usage func()) error {
+	var config struct {
+		KeyFromFile string
+	}
+
+	flags := flag.NewFlagSet("get <file> <key.path>", flag.ExitOnError)
+	flags.StringVar(&config.KeyFromFile, "keyfile", "", "Load private key from `file`")
+	flags.Usage = usage
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		return fmt.Errorf("expected <file> <key.path> arguments")
+	}
+
+	hidden, err := loadHiddenFile(flags.Arg(0), config.KeyFromFile)
+	if err != nil {
+		return err
+	}
+	format, err := valueFormat(hidden.entry)
+	if err != nil {
+		return err
+	}
+
+	value, found, err := format.FindValue(hidden.plain, flags.Arg(1))
+	if err != nil {
+		return fmt.Errorf("failed to parse %q: %w", hidden.entry.Path, err)
+	}
+	if !found {
+		return fmt.Errorf("no value %q in %q", flags.Arg(1), hidden.entry.Path)
+	}
+
+	decoded, err := format.DecodeValue(hidden.plain[value.Start:value.End])
+	if err != nil {
+		return fmt.Errorf("failed to decode value %q: %w", flags.Arg(1), err)
+	}
+
+	if strings.HasSuffix(decoded, "\n") {
+		fmt.Print(decoded)
+	} else {
+		fmt.Println(decoded)
+	}
+	return nil
+}
+
+// Set updates a single value of a hidden structured file, and hides it
+// again. The revealed file is updated too, if it is in sync.
+// The value is read from stdin if not given, or given as "-".
+func Set(args []string, usage func()) error {
+	var config struct {
+		KeyFromFile string
+	}
+
+	flags := flag.NewFlagSet("set <file> <key.path> [value | -]", flag.ExitOnError)
+	flags.StringVar(&config.KeyFromFile, "keyfile", "", "Load private key from `file`")
+	flags.Usage = usage
+	flags.Parse(args)
+
+	if flags.NArg() != 2 && flags.NArg() != 3 {
+		return fmt.Errorf("expected <file> <key.path> [value | -] arguments")
+	}
+	path := flags.Arg(1)
+
+	var value string
+	if flags.NArg() == 3 && flags.Arg(2) != "-" {
+		value = flags.Arg(2)
+	} else {
+		input, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		value = strings.TrimSuffix(strings.TrimSuffix(string(input), "\n"), "\r")
+	}
+
+	hidden, err := loadHiddenFile(flags.Arg(0), config.KeyFromFile)
+	if err != nil {
+		return err
+	}
+	format, err := valueFormat(hidden.entry)
+	if err != nil {
+		return err
+	}
+
+	revealed, err := ensureNotModified(hidden)
+	if err != nil {
+		return err
+	}
+
+	updated, err := setValue(format, hidden.plain, path, value)
+	if err != nil {
+		return fmt.Errorf("cannot set value %q in %q: %w", path, hidden.entry.Path, err)
+	}
+
+	return rehide(hidden, updated, revealed)
+}
+
+// hiddenFile is the decrypted contents of a hidden file.
+type hiddenFile struct {
+	entry       utils.SecureFile
+	plain       []byte
+	private     []byte
+	privatePath utils.AbsolutePath
+	identity    age.Identity
+	identities  []age.Identity
+	hasher      utils.FileHasher
+}
+
+// loadHiddenFile decrypts the private file of the given file in memory.
+func loadHiddenFile(file string, keyFile string) (hiddenFile, error) {
+	var hidden hiddenFile
+
+	err := utils.EnsureInitialized()
+	if err != nil {
+		return hidden, err
+	}
+
+	hidden.identity, err = loadPrivateKey(keyFile)
+	if err != nil {
+		return hidden, err
+	}
+
+	err = unlockFileList(hidden.identity)
+	if err != nil {
+		return hidden, err
+	}
+
+	path, err := repoRelativeArgument(file)
+	if err != nil {
+		return hidden, err
+	}
+
+	fileList, err := utils.LoadFileList()
+	if err != nil {
+		return hidden, err
+	}
+
+	entry, found := fileList.FindFile(path)
+	if !found || entry.Hash == "" {
+		return hidden, fmt.Errorf("%q is not hidden", path)
+	}
+	hidden.entry = entry
+
+	hidden.hasher, err = loadFileHasher(fileList, hidden.identity)
+	if err != nil {
+		return hidden, err
+	}
+
+	privateFile, err := utils.PrivatePath(entry)
+	if err != nil {
+		return hidden, err
+	}
+	hidden.privatePath, err = utils.RepoAbsolute(privateFile)
+	if err != nil {
+		return hidden, err
+	}
+	hidden.private, err = os.ReadFile(hidden.privatePath.Absolute())
+	if err != nil {
+		return hidden, err
+	}
+
+	hidden.identities, err = fileIdentities(hidden.identity)
+	if err != nil {
+		return hidden, err
+	}
+
+	hidden.plain, err = decryptPrivateData(entry, hidden.private, hidden.identities...)
+	if err != nil {
+		return hidden, fmt.Errorf("failed to decrypt %q: %w", path, err)
+	}
+
+	return hidden, nil
+}
+
+// valueFormat returns the format of a file's values.
+// Files encrypted as a whole are read in the format given by their name.
+func valueFormat(entry utils.SecureFile) (utils.Format, error) {
+	if entry.Format != utils.WholeFile {
+		return entry.Format, nil
+	}
+	format, known := utils.GuessFormat(entry.Path)
+	if !known {
+		return format, fmt.Errorf("%q is not a dotenv, JSON or YAML file, set its format using 'add -format'", entry.Path)
+	}
+	return format, nil
+}
+
+// ensureNotModified refuses to update hidden files with local modifications,
+// or updated private files. Returns true if the file is revealed.
+func ensureNotModified(hidden hiddenFile) (bool, error) {
+	status, err := getFileStatus(hidden.hasher, hidden.entry)
+	if err != nil {
+		return false, err
+	}
+	if status != hiddenInSync && status != hiddenNotRevealed {
+		return false, fmt.Errorf("%q is out of sync with its private file, hide or reveal it first", hidden.entry.Path)
+	}
+	return status == hiddenInSync, nil
+}
+
+// rehide hides updated contents of a hidden file, like hide does,
+// and writes the revealed file too, if revealed.
+func rehide(hidden hiddenFile, updated []byte, revealed bool) error {
+	keys, err := loadHideKeys(hidden.identity)
+	if err != nil {
+		return err
+	}
+
+	err = hideData(hidden.entry, updated, keys, hidden.identity, revealed)
+	if err != nil {
+		return err
+	}
+
+	if revealed {
+		fullPath, err := utils.RepoAbsolute(hidden.entry.Path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(fullPath.Absolute(), updated, 0660)
+	}
+	return nil
+}
+
+// Names of new dotenv variables
+var dotenvName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// setValue replaces a value in a structured file. New dotenv variables
+// are added at the end. Other formats only support existing values.
+func setValue(format utils.Format, plain []byte, path string, value string) ([]byte, error) {
+	if format == utils.DotenvFormat && strings.ContainsAny(value, "$`") && strings.Contains(value, "'") {
+		return nil, fmt.Errorf("dotenv values containing both single quotes and '$' or '`' can not be quoted safely")
+	}
+
+	existing, found, err := format.FindValue(plain, path)
+	if err != nil {
+		return nil, err
+	}
+
+	if found {
+		raw := format.EncodeValue(value, plain[existing.Start:existing.End])
+		updated := append([]byte{}, plain[:existing.Start]...)
+		updated = append(updated, raw...)
+		return append(updated, plain[existing.End:]...), nil
+	}
+
+	if format != utils.DotenvFormat {
+		return nil, fmt.Errorf("no such value, only existing values can be set")
+	}
+	if !dotenvName.MatchString(path) {
+		return nil, fmt.Errorf("invalid variable name %q", path)
+	}
+
+	raw := format.EncodeValue(value, nil)
+
+	// Empty variables have no value to replace
+	empty := regexp.MustCompile(`(?m)^([ \t]*(?:export[ \t]+)?` + regexp.QuoteMeta(path) + `[ \t]*=)\r?$`)
+	if location := empty.FindSubmatchIndex(plain); location != nil {
+		updated := append([]byte{}, plain[:location[3]]...)
+		updated = append(updated, raw...)
+		return append(updated, plain[location[3]:]...), nil
+	}
+
+	updated := append([]byte{}, plain...)
+	if len(updated) != 0 && !bytes.HasSuffix(updated, []byte("\n")) {
+		updated = append(updated, '\n')
+	}
+	updated = append(updated, path+"="...)
+	updated = append(updated, raw...)
+	return append(updated, '\n'), nil
+}
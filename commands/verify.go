@@ -0,0 +1,328 @@
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"filippo.io/age/armor"
+
+	"github.com/erkkah/git-private/utils"
+)
+
+// VerifyPush validates pushed commits, for use in server side hooks.
+// Called as "verify-push <old> <new> <ref>", as from an update hook,
+// or without arguments, reading "<old> <new> <ref>" lines from stdin,
+// as from a pre-receive hook.
+// Does not need a working tree or a private key.
+func VerifyPush(args []string, usage func()) error {
+	flags := flag.NewFlagSet("verify-push [<old> <new> <ref>]", flag.ExitOnError)
+	flags.Usage = usage
+	flags.Parse(args)
+
+	var updates [][]string
+
+	switch flags.NArg() {
+	case 3:
+		updates = append(updates, flags.Args())
+	case 0:
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) != 3 {
+				return fmt.Errorf("unexpected input line %q", scanner.Text())
+			}
+			updates = append(updates, fields)
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("expected <old> <new> <ref> arguments")
+	}
+
+	var problems []string
+	// Set if any commit uses the blob layout, where the file list can not be read
+	encryptedFileList := false
+
+	for _, update := range updates {
+		oldRev, newRev, ref := update[0], update[1], update[2]
+
+		if isNullRevision(newRev) {
+			// Deleted ref
+			continue
+		}
+
+		var commits []string
+		var err error
+		if isNullRevision(oldRev) {
+			commits, err = utils.GitRevList(newRev, "--not", "--all")
+		} else {
+			commits, err = utils.GitRevList(oldRev + ".." + newRev)
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, commit := range commits {
+			commitProblems, encrypted, err := verifyCommit(commit)
+			if err != nil {
+				return err
+			}
+			encryptedFileList = encryptedFileList || encrypted
+			for _, problem := range commitProblems {
+				problems = append(problems, fmt.Sprintf("%s %.10s: %s", ref, commit, problem))
+			}
+		}
+	}
+
+	if encryptedFileList {
+		fmt.Fprintf(os.Stderr, "note: the file index of the blob layout is encrypted, so hidden files committed in plain text "+
+			"are not found, and missing private files only if removed without updating the index\n")
+	}
+
+	if len(problems) != 0 {
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+		return fmt.Errorf("push rejected, %d problem%s found", len(problems), pluralSuffix(len(problems)))
+	}
+
+	return nil
+}
+
+func isNullRevision(revision string) bool {
+	return strings.Trim(revision, "0") == ""
+}
+
+// verifyCommit checks the given commit, returning the problems found.
+// Also returns true if the commit uses the blob layout, where hidden files
+// can not be matched to their private files without the hash key.
+func verifyCommit(commit string) ([]string, bool, error) {
+	var problems []string
+
+	stateDir, err := utils.RepoStateDir()
+	if err != nil {
+		return nil, false, err
+	}
+	pathsFile := path.Join(stateDir.Relative(), "paths.json")
+	indexFile := path.Join(stateDir.Relative(), "index.dat")
+	settingsFile := path.Join(stateDir.Relative(), "settings.json")
+
+	treeFiles, err := utils.GitListTree(commit)
+	if err != nil {
+		return nil, false, err
+	}
+	inTree := map[utils.RepoRelativePath]bool{}
+	for _, file := range treeFiles {
+		inTree[file] = true
+	}
+
+	var settings utils.Settings
+	if inTree[utils.RepoRelativePath(settingsFile)] {
+		settingsData, _, err := utils.GitReadBlob(commit + ":" + settingsFile)
+		if err != nil {
+			return nil, false, err
+		}
+		settings, err = utils.ParseSettings(settingsData)
+		if err != nil {
+			return []string{fmt.Sprintf("invalid settings %q: %v", settingsFile, err)}, false, nil
+		}
+	}
+
+	// Formats of structured private files, encrypted value by value
+	formats := map[utils.RepoRelativePath]utils.Format{}
+	haveFileList := inTree[utils.RepoRelativePath(pathsFile)]
+
+	if haveFileList {
+		pathsData, _, err := utils.GitReadBlob(commit + ":" + pathsFile)
+		if err != nil {
+			return nil, false, err
+		}
+		fileList, err := utils.ParseFileList(pathsData)
+		if err != nil {
+			return []string{fmt.Sprintf("invalid file list %q: %v", pathsFile, err)}, false, nil
+		}
+
+		for _, file := range treeFiles {
+			if fileList.Covers(file) {
+				problems = append(problems, fmt.Sprintf("private file %q committed in plain text", file))
+			}
+		}
+		for _, file := range fileList.AllFiles() {
+			privatePath, err := settings.Layout.PrivatePath(file)
+			if err != nil {
+				return nil, false, err
+			}
+			if file.Hash != "" && !inTree[privatePath] {
+				problems = append(problems, fmt.Sprintf("hidden file %q has no private file", file.Path))
+			}
+			if file.Format != utils.WholeFile {
+				formats[privatePath] = file.Format
+			}
+		}
+	}
+
+	blobLayout := settings.Layout == utils.BlobLayout
+	if blobLayout {
+		// Private files are added and removed together with their index entries
+		changed, err := changedWithoutFile(commit, utils.RepoRelativePath(indexFile))
+		if err != nil {
+			return nil, false, err
+		}
+		for _, file := range changed {
+			if utils.IsPrivateFile(file) && !inTree[file] {
+				problems = append(problems, fmt.Sprintf("private file %q removed without updating the file index", file))
+			}
+		}
+	}
+
+	changes, err := utils.GitCommitChanges(commit)
+	if err != nil {
+		return nil, false, err
+	}
+
+	for _, file := range changes {
+		if !utils.IsPrivateFile(file) {
+			continue
+		}
+		contents, _, err := utils.GitReadBlob(commit + ":" + file.Relative())
+		if err != nil {
+			return nil, false, err
+		}
+		if isAgeFile(contents) {
+			continue
+		}
+		if format, found := formats[file]; found {
+			unsealed, err := unsealedValues(format, contents)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("private file %q is not valid %s: %v", file, format, err))
+			}
+			for _, path := range unsealed {
+				problems = append(problems, fmt.Sprintf("value %q in private file %q is not encrypted", path, file))
+			}
+			continue
+		}
+		// Without a readable file list, formats are unknown
+		if !haveFileList && isFullySealed(contents) {
+			continue
+		}
+		problems = append(problems, fmt.Sprintf("private file %q is not a valid age file", file))
+	}
+
+	return problems, blobLayout, nil
+}
+
+// changedWithoutFile lists files changed by the given commit, compared
+// to each parent where the given file was not changed.
+func changedWithoutFile(commit string, file utils.RepoRelativePath) ([]utils.RepoRelativePath, error) {
+	revisions, err := utils.GitRevList("--parents", "-n", "1", commit)
+	if err != nil {
+		return nil, err
+	}
+
+	var changedFiles []utils.RepoRelativePath
+
+	for _, parent := range revisions[1:] {
+		changed, err := utils.GitChangedFiles(parent, commit)
+		if err != nil {
+			return nil, err
+		}
+		fileChanged := false
+		for _, changedFile := range changed {
+			fileChanged = fileChanged || changedFile == file
+		}
+		if !fileChanged {
+			changedFiles = append(changedFiles, changed...)
+		}
+	}
+
+	return changedFiles, nil
+}
+
+// isFullySealed checks that the data is a structured file in some format,
+// with all values encrypted.
+func isFullySealed(data []byte) bool {
+	if !bytes.Contains(data, []byte(sealedPrefix)) {
+		return false
+	}
+	for _, format := range []utils.Format{utils.DotenvFormat, utils.JSONFormat, utils.YAMLFormat} {
+		unsealed, err := unsealedValues(format, data)
+		if err == nil && len(unsealed) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// isAgeFile checks that the data has a well-formed age header,
+// followed by a payload. Armored data is decoded first.
+func isAgeFile(data []byte) bool {
+	if isArmored(data) {
+		decoded, err := io.ReadAll(armor.NewReader(bytes.NewReader(data)))
+		if err != nil {
+			return false
+		}
+		data = decoded
+	}
+
+	reader := bufio.NewReader(bytes.NewReader(data))
+
+	readLine := func() (string, bool) {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return "", false
+		}
+		return strings.TrimSuffix(line, "\n"), true
+	}
+
+	line, ok := readLine()
+	if !ok || line != "age-encryption.org/v1" {
+		return false
+	}
+
+	stanzas := 0
+	for {
+		line, ok = readLine()
+		if !ok {
+			return false
+		}
+		if strings.HasPrefix(line, "-> ") {
+			stanzas++
+			continue
+		}
+		if strings.HasPrefix(line, "--- ") {
+			mac, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(line, "--- "))
+			if err != nil || len(mac) != 32 {
+				return false
+			}
+			break
+		}
+		if stanzas == 0 {
+			return false
+		}
+		// Stanza body
+		if _, err := base64.RawStdEncoding.DecodeString(line); err != nil {
+			return false
+		}
+	}
+
+	if stanzas == 0 {
+		return false
+	}
+
+	// Payload nonce, followed by at least one authenticated chunk
+	payload, err := io.ReadAll(reader)
+	if err != nil {
+		return false
+	}
+	const nonceSize = 16
+	const tagSize = 16
+	return len(payload) >= nonceSize+tagSize
+}
@@ -16,10 +16,14 @@ func main() {
 		os.Exit(1)
 	}
 
-	err := checkSetup()
+	cmd := args[1]
+
+	var err error
+	if !runsWithoutWorkTree[cmd] {
+		err = checkSetup()
+	}
 
 	if err == nil {
-		cmd := args[1]
 		err = runCommand(cmd, os.Args[2:])
 	}
 	if err != nil {
@@ -28,6 +32,11 @@ func main() {
 	}
 }
 
+// Commands that can run in bare repos
+var runsWithoutWorkTree = map[string]bool{
+	"verify-push": true,
+}
+
 func verifyStateDirIsNotIgnored() error {
 	stateDir, err := utils.StateDir()
 	if err != nil {
@@ -70,17 +79,38 @@ func appName() string {
 
 func usage() {
 	fmt.Fprintf(os.Stderr, `Usage:
-	%[1]s init
-	%[1]s add <FILE...>
-	%[1]s remove <FILE...>
-	%[1]s hide [-keyfile FILE] [-clean] [FILE...]
+	%[1]s init [-layout mirror|blobs]
+	%[1]s add [-untrack] [-format dotenv|json|yaml|whole] <FILE | DIR | PATTERN...>
+	%[1]s remove <FILE | DIR | PATTERN...>
+	%[1]s mv <SOURCE> <DESTINATION>
+	%[1]s hide [-keyfile FILE] [-clean] [-force] [FILE...]
 	%[1]s reveal [-keyfile FILE] [-force] [FILE...]
+	%[1]s get [-keyfile FILE] <FILE> <KEY.PATH>
+	%[1]s set [-keyfile FILE] <FILE> <KEY.PATH> [VALUE | -]
+	%[1]s edit [-keyfile FILE] <FILE>
+	%[1]s cat [-keyfile FILE] <FILE> [-rev REV]
+	%[1]s log [-keyfile FILE] <FILE> [-unredacted]
 	%[1]s keys list [-keyfile FILE]
 	%[1]s keys add [-keyfile FILE] [-id ID] [-readonly] <-pubfile FILE | public key>
 	%[1]s keys remove [-keyfile FILE] <-id ID | ID>
 	%[1]s keys generate -keyfile FILE [-pubfile FILE]
+	%[1]s envelope <enable|disable> [-keyfile FILE]
+	%[1]s relayout <sibling|mirror|blobs> [-keyfile FILE]
+	%[1]s padding <none|pow2|buckets> [-keyfile FILE]
+	%[1]s reencrypt [-keyfile FILE]
+	%[1]s convert <-armor | -binary>
+	%[1]s compression <enable|disable>
 	%[1]s clean [-force]
-	%[1]s status
+	%[1]s status [-keyfile FILE]
+	%[1]s sync [-keyfile FILE]
+	%[1]s filter-install [PATTERN...]
+	%[1]s diff-install
+	%[1]s merge-install
+	%[1]s hooks install [-autohide]
+	%[1]s hooks uninstall
+	%[1]s verify-push [<old> <new> <ref>]
+	%[1]s scan [-add [-untrack]]
+	%[1]s scan-history [-json]
 
 Example:
 	$ git-private init
@@ -95,12 +125,37 @@ func runCommand(cmd string, args []string) error {
 		"init":   commands.Init,
 		"add":    commands.Add,
 		"remove": commands.Remove,
+		"mv":     commands.Move,
 		"hide":   commands.Hide,
 		"reveal": commands.Reveal,
+		"get":    commands.Get,
+		"set":    commands.Set,
+		"edit":   commands.Edit,
+		"cat":    commands.Cat,
+		"log":    commands.Log,
 		"keys":   commands.Keys,
 		"clean":  commands.Clean,
 		"status": commands.Status,
+		"sync":   commands.Sync,
 		"help":   help,
+
+		"filter-install": commands.FilterInstall,
+		"filter-clean":   commands.FilterClean,
+		"filter-smudge":  commands.FilterSmudge,
+		"diff-install":   commands.DiffInstall,
+		"textconv":       commands.Textconv,
+		"merge-install":  commands.MergeInstall,
+		"merge-driver":   commands.MergeDriver,
+		"hooks":          commands.Hooks,
+		"verify-push":    commands.VerifyPush,
+		"scan":           commands.Scan,
+		"scan-history":   commands.ScanHistory,
+		"envelope":       commands.Envelope,
+		"relayout":       commands.Relayout,
+		"padding":        commands.Padding,
+		"reencrypt":      commands.Reencrypt,
+		"convert":        commands.Convert,
+		"compression":    commands.Compression,
 	}
 	command, found := cmds[cmd]
 	if !found {
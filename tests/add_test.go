@@ -1,6 +1,7 @@
 package tests
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/erkkah/git-private/commands"
@@ -13,6 +14,9 @@ func TestAdd(t *testing.T) {
 			{"no args", testAddNoArgsFails},
 			{"no file", testAddNonExistingFileFails},
 			{"add single file", testAddSingleFileWorks},
+			{"tracked file", testAddTrackedFileFails},
+			{"tracked files listed", testAddTrackedFilesListed},
+			{"untrack tracked file", testAddUntrackTrackedFileWorks},
 		},
 	}, t)
 }
@@ -46,3 +50,49 @@ func testAddSingleFileWorks(t *testing.T) {
 		t.Fatal()
 	}
 }
+
+func testAddTrackedFileFails(t *testing.T) {
+	makeFile("tracked", t)
+	runGit(t, "add", "tracked")
+
+	err := commands.Add([]string{"tracked"}, func() {})
+	if err == nil {
+		t.Fatal("adding tracked file should fail")
+	}
+}
+
+func testAddTrackedFilesListed(t *testing.T) {
+	makeFile("a.pem", t)
+	makeFile("b.pem", t)
+	runGit(t, "add", "a.pem", "b.pem")
+
+	err := commands.Add([]string{"*.pem"}, func() {})
+	if err == nil {
+		t.Fatal("adding pattern matching tracked files should fail")
+	}
+	if !strings.Contains(err.Error(), `"a.pem"`) || !strings.Contains(err.Error(), `"b.pem"`) {
+		t.Fatalf("not all tracked files listed: %v", err)
+	}
+}
+
+func testAddUntrackTrackedFileWorks(t *testing.T) {
+	makeFile("tracked", t)
+	runGit(t, "add", "tracked")
+
+	err := commands.Add([]string{"-untrack", "tracked"}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if runGit(t, "ls-files", "tracked") != "" {
+		t.Fatal("file still in index")
+	}
+
+	list, err := utils.LoadFileList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Files) != 1 || list.Files[0].Path != "tracked" {
+		t.Fatal()
+	}
+}
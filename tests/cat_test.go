@@ -0,0 +1,123 @@
+package tests
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/erkkah/git-private/commands"
+)
+
+func TestCat(t *testing.T) {
+	runAll(Suite{
+		name: "cat", tests: []NamedTest{
+			{"work tree", testCatWorkTree},
+			{"structured", testCatStructured},
+			{"revision", testCatRevision},
+			{"revision before move", testCatRevisionBeforeMove},
+		},
+	}, t)
+}
+
+func catFile(t *testing.T, args ...string) string {
+	output := withStdio(nil, func() error {
+		return commands.Cat(append([]string{"-keyfile", oneKey}, args...), func() {})
+	}, t)
+	return string(output)
+}
+
+func testCatWorkTree(t *testing.T) {
+	err := commands.Padding([]string{"pow2", "-keyfile", oneKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = commands.Compression([]string{"enable"}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contents := strings.Repeat("compressible secret\n", 100)
+	hideStructured(t, "notes.txt", contents, "whole")
+	err = os.Remove("notes.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if output := catFile(t, "notes.txt"); output != contents {
+		t.Fatalf("unexpected output %q", output)
+	}
+	if _, err := os.Stat("notes.txt"); err == nil {
+		t.Fatal("file revealed by cat")
+	}
+
+	err = commands.Cat([]string{"-keyfile", oneKey, "other.txt"}, func() {})
+	if err == nil {
+		t.Fatal("cat of untracked file should fail")
+	}
+}
+
+func testCatStructured(t *testing.T) {
+	hideStructured(t, "config.json", jsonSample, "json")
+
+	if output := catFile(t, "config.json"); output != jsonSample {
+		t.Fatalf("unexpected output %q", output)
+	}
+}
+
+func testCatRevision(t *testing.T) {
+	hideStructured(t, "notes.txt", "first\n", "whole")
+	runGit(t, "add", "-A")
+	runGit(t, "commit", "-m", "first")
+
+	err := os.WriteFile("notes.txt", []byte("second\n"), 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = commands.Hide([]string{"-keyfile", oneKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if output := catFile(t, "notes.txt"); output != "second\n" {
+		t.Fatalf("unexpected output %q", output)
+	}
+	if output := catFile(t, "notes.txt", "-rev", "HEAD"); output != "first\n" {
+		t.Fatalf("unexpected output %q", output)
+	}
+	if output := catFile(t, "-rev", "HEAD", "notes.txt"); output != "first\n" {
+		t.Fatalf("unexpected output %q", output)
+	}
+
+	err = commands.Cat([]string{"-keyfile", oneKey, "-rev", "HEAD~1", "notes.txt"}, func() {})
+	if err == nil {
+		t.Fatal("cat of missing revision should fail")
+	}
+}
+
+func testCatRevisionBeforeMove(t *testing.T) {
+	hideStructured(t, "notes.txt", "first\n", "whole")
+	runGit(t, "add", "-A")
+	runGit(t, "commit", "-m", "first")
+
+	err := commands.Move([]string{"notes.txt", "moved.txt"}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = commands.Relayout([]string{"mirror"}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, "add", "-A")
+	runGit(t, "commit", "-m", "moved")
+
+	if output := catFile(t, "-rev", "HEAD~1", "notes.txt"); output != "first\n" {
+		t.Fatalf("unexpected output %q", output)
+	}
+	if output := catFile(t, "-rev", "HEAD", "moved.txt"); output != "first\n" {
+		t.Fatalf("unexpected output %q", output)
+	}
+	err = commands.Cat([]string{"-keyfile", oneKey, "-rev", "HEAD", "notes.txt"}, func() {})
+	if err == nil {
+		t.Fatal("cat of file not tracked in revision should fail")
+	}
+}
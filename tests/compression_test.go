@@ -0,0 +1,83 @@
+package tests
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/erkkah/git-private/commands"
+	"github.com/erkkah/git-private/utils"
+)
+
+func TestCompression(t *testing.T) {
+	runAll(Suite{
+		name: "compression", tests: []NamedTest{
+			{"payload", testCompressedPayloadRoundTrip},
+			{"hide", testCompressionShrinksPrivateFiles},
+		},
+	}, t)
+}
+
+func testCompressedPayloadRoundTrip(t *testing.T) {
+	inputs := [][]byte{
+		[]byte(strings.Repeat("compressible ", 1000)),
+		[]byte("short"),
+		// Looks like a payload header
+		[]byte("\x00git-private\x01\x00\x00\x00\x00\x00\x00\x00\x01x"),
+	}
+
+	for _, plain := range inputs {
+		for _, compress := range []bool{false, true} {
+			payload, err := utils.EncodePayload(plain, compress, utils.NoPadding)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := utils.DecodePayload(payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(plain, decoded) {
+				t.Fatalf("payload differs after decoding, compress %v", compress)
+			}
+		}
+	}
+}
+
+func testCompressionShrinksPrivateFiles(t *testing.T) {
+	err := commands.Compression([]string{"enable"}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plain := []byte(strings.Repeat("-----BEGIN CERTIFICATE-----\n", 1000))
+	err = os.WriteFile("bundle.pem", plain, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addAndHide(t, "bundle.pem")
+
+	private, err := os.Stat("bundle.pem.private")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if private.Size() >= int64(len(plain))/2 {
+		t.Fatalf("private file not compressed, %d bytes", private.Size())
+	}
+
+	err = os.Remove("bundle.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = commands.Reveal([]string{"-keyfile", oneKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revealed, err := os.ReadFile("bundle.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plain, revealed) {
+		t.Fatal("revealed file differs")
+	}
+}
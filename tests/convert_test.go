@@ -0,0 +1,113 @@
+package tests
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/erkkah/git-private/commands"
+)
+
+func TestConvert(t *testing.T) {
+	runAll(Suite{
+		name: "convert", tests: []NamedTest{
+			{"armor", testConvertToArmor},
+			{"hide armored", testHideArmored},
+		},
+	}, t)
+}
+
+func hideForConvert(t *testing.T) []byte {
+	makeFile("secret", t)
+	addAndHide(t, "secret")
+	plain, err := os.ReadFile("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return plain
+}
+
+func isArmoredFile(t *testing.T, file string) bool {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.HasPrefix(string(data), "-----BEGIN AGE ENCRYPTED FILE-----")
+}
+
+func revealAndCompare(t *testing.T, plain []byte) {
+	err := os.Remove("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = commands.Reveal([]string{"-keyfile", oneKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revealed, err := os.ReadFile("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plain, revealed) {
+		t.Fatal("revealed file differs")
+	}
+}
+
+func testConvertToArmor(t *testing.T) {
+	plain := hideForConvert(t)
+
+	err := commands.Convert([]string{"-armor"}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isArmoredFile(t, "secret.private") {
+		t.Fatal("private file not armored")
+	}
+	err = commands.Status([]string{"-keyfile", oneKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revealAndCompare(t, plain)
+
+	err = commands.Convert([]string{"-binary"}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isArmoredFile(t, "secret.private") {
+		t.Fatal("private file still armored")
+	}
+	revealAndCompare(t, plain)
+}
+
+func testHideArmored(t *testing.T) {
+	err := commands.Convert([]string{"-armor"}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := hideForConvert(t)
+	if !isArmoredFile(t, "secret.private") {
+		t.Fatal("private file not armored")
+	}
+
+	// Line endings converted on checkout
+	armored, err := os.ReadFile("secret.private")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile("secret.private", bytes.ReplaceAll(armored, []byte("\n"), []byte("\r\n")), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = commands.Reveal([]string{"-keyfile", oneKey, "-force"}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revealed, err := os.ReadFile("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plain, revealed) {
+		t.Fatal("revealed file differs")
+	}
+}
@@ -0,0 +1,111 @@
+package tests
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/erkkah/git-private/commands"
+	"github.com/erkkah/git-private/utils"
+)
+
+func TestDiff(t *testing.T) {
+	runAll(Suite{
+		name: "diff", tests: []NamedTest{
+			{"install", testDiffInstallWorks},
+			{"decrypted diff", testDiffShowsDecryptedChanges},
+			{"no private key", testTextconvWithoutKey},
+			{"not a recipient", testTextconvWithoutAccess},
+		},
+	}, t)
+}
+
+func testDiffInstallWorks(t *testing.T) {
+	err := commands.DiffInstall([]string{}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attributes, err := os.ReadFile(".gitattributes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(attributes), "*"+utils.PrivateExtension+" diff=gitprivate") {
+		t.Fatalf("diff attribute not written:\n%s", attributes)
+	}
+
+	config, err := exec.Command("git", "config", "diff.gitprivate.textconv").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(config)) != "git-private textconv" {
+		t.Fatalf("unexpected textconv config %q", config)
+	}
+}
+
+func testDiffShowsDecryptedChanges(t *testing.T) {
+	installTool(t)
+	key, err := filepath.Abs(oneKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GIT_PRIVATE_KEYFILE", key)
+
+	err = os.WriteFile("notes.txt", []byte("first\n"), 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addAndHide(t, "notes.txt")
+	err = commands.DiffInstall([]string{}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, "add", "-A")
+	runGit(t, "commit", "-m", "first")
+
+	err = os.WriteFile("notes.txt", []byte("second\n"), 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = commands.Hide([]string{}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff := runGit(t, "diff", "--", "notes.txt"+utils.PrivateExtension)
+	if !strings.Contains(diff, "-first\n+second") {
+		t.Fatalf("expected decrypted changes in diff:\n%s", diff)
+	}
+}
+
+func textconv(t *testing.T) string {
+	makeFile("secret", t)
+	addAndHide(t, "secret")
+
+	output := withStdio(nil, func() error {
+		return commands.Textconv([]string{"secret" + utils.PrivateExtension}, func() {})
+	}, t)
+	return string(output)
+}
+
+func testTextconvWithoutKey(t *testing.T) {
+	t.Setenv(utils.PrivateKeyVariable, "")
+	t.Setenv(utils.PrivateKeyFileVariable, "")
+
+	output := textconv(t)
+	if !strings.HasPrefix(output, "<git-private: encrypted content ") || !strings.HasSuffix(output, ", no private key available>\n") {
+		t.Fatalf("unexpected placeholder %q", output)
+	}
+}
+
+func testTextconvWithoutAccess(t *testing.T) {
+	t.Setenv(utils.PrivateKeyVariable, "")
+	t.Setenv(utils.PrivateKeyFileVariable, anotherKey)
+
+	output := textconv(t)
+	if !strings.HasPrefix(output, "<git-private: encrypted content ") || !strings.HasSuffix(output, ", no access>\n") {
+		t.Fatalf("unexpected placeholder %q", output)
+	}
+}
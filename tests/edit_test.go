@@ -0,0 +1,150 @@
+package tests
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/erkkah/git-private/commands"
+)
+
+func TestEdit(t *testing.T) {
+	runAll(Suite{
+		name: "edit", tests: []NamedTest{
+			{"revealed", testEditRevealedFile},
+			{"not revealed", testEditHiddenFile},
+			{"unchanged", testEditUnchanged},
+			{"modified", testEditRefusesModifiedFile},
+			{"terminated", testEditRemovesFileWhenTerminated},
+		},
+	}, t)
+}
+
+func editFile(t *testing.T, file string, editor string) error {
+	t.Setenv("GIT_EDITOR", editor)
+	return commands.Edit([]string{"-keyfile", oneKey, file}, func() {})
+}
+
+func testEditRevealedFile(t *testing.T) {
+	hideStructured(t, ".env", dotenvSample, "dotenv")
+
+	err := editFile(t, ".env", "echo ADDED=value >>")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	revealed, err := os.ReadFile(".env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(revealed) != dotenvSample+"ADDED=value\n" {
+		t.Fatalf("revealed file not updated:\n%s", revealed)
+	}
+	if value := getValue(t, ".env", "ADDED"); value != "value\n" {
+		t.Fatalf("private file not updated, got %q", value)
+	}
+
+	// In sync, so nothing to hide again
+	private, err := os.ReadFile(".env.private")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = commands.Hide([]string{"-keyfile", oneKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := os.ReadFile(".env.private")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != string(private) {
+		t.Fatal("edited file not in sync")
+	}
+}
+
+func testEditHiddenFile(t *testing.T) {
+	hideStructured(t, "notes.txt", "first\n", "whole")
+	err := os.Remove("notes.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = editFile(t, "notes.txt", "echo second >>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat("notes.txt"); err == nil {
+		t.Fatal("file revealed by edit")
+	}
+
+	err = commands.Reveal([]string{"-keyfile", oneKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revealed, err := os.ReadFile("notes.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(revealed) != "first\nsecond\n" {
+		t.Fatalf("unexpected contents %q", revealed)
+	}
+}
+
+func testEditUnchanged(t *testing.T) {
+	private := hideStructured(t, "notes.txt", "first\n", "whole")
+
+	err := editFile(t, "notes.txt", "true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := os.ReadFile("notes.txt.private")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != private {
+		t.Fatal("unchanged file encrypted again")
+	}
+
+	err = editFile(t, "notes.txt", "false")
+	if err == nil {
+		t.Fatal("failing editor should fail edit")
+	}
+}
+
+func testEditRefusesModifiedFile(t *testing.T) {
+	hideStructured(t, "notes.txt", "first\n", "whole")
+
+	err := os.WriteFile("notes.txt", []byte("local change\n"), 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = editFile(t, "notes.txt", "echo second >>")
+	if err == nil {
+		t.Fatal("editing modified file should fail")
+	}
+}
+
+func testEditRemovesFileWhenTerminated(t *testing.T) {
+	private := hideStructured(t, "notes.txt", "first\n", "whole")
+
+	// The editor records the decrypted file, and terminates the tool
+	err := editFile(t, "notes.txt", `echo "$1" > edited; echo second >> "$1"; kill -TERM $PPID; sleep 5; true`)
+	if err == nil {
+		t.Fatal("terminated edit should fail")
+	}
+
+	edited, err := os.ReadFile("edited")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(strings.TrimSpace(string(edited))); err == nil {
+		t.Fatal("decrypted file left after termination")
+	}
+	after, err := os.ReadFile("notes.txt.private")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != private {
+		t.Fatal("changes hidden after termination")
+	}
+}
@@ -0,0 +1,167 @@
+package tests
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/erkkah/git-private/commands"
+)
+
+func TestEnvelope(t *testing.T) {
+	runAll(Suite{
+		name: "envelope", tests: []NamedTest{
+			{"enable", testEnvelopeEnableKeepsFilesReadable},
+			{"add key", testEnvelopeAddKeyKeepsPrivateFiles},
+			{"remove key", testEnvelopeRemoveKeyRotatesDataKey},
+			{"interrupted rotation", testEnvelopeInterruptedRotationKeepsFilesReadable},
+		},
+	}, t)
+}
+
+func setupEnvelope(t *testing.T) []byte {
+	makeFile("secret", t)
+	addAndHide(t, "secret")
+	err := commands.Envelope([]string{"enable", "-keyfile", oneKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plain, err := os.ReadFile("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return plain
+}
+
+func revealWith(t *testing.T, key string) error {
+	err := os.Remove("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return commands.Reveal([]string{"-keyfile", key}, func() {})
+}
+
+func testEnvelopeEnableKeepsFilesReadable(t *testing.T) {
+	plain := setupEnvelope(t)
+
+	err := revealWith(t, oneKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revealed, err := os.ReadFile("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plain, revealed) {
+		t.Fatal("revealed file differs")
+	}
+}
+
+func testEnvelopeAddKeyKeepsPrivateFiles(t *testing.T) {
+	setupEnvelope(t)
+
+	before, err := os.ReadFile("secret.private")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = commands.Keys([]string{"add", "-id", "another", "-pubfile", anotherPublicKey, "-keyfile", oneKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := os.ReadFile("secret.private")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Fatal("private file was re-encrypted")
+	}
+
+	err = revealWith(t, anotherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testEnvelopeRemoveKeyRotatesDataKey(t *testing.T) {
+	setupEnvelope(t)
+
+	err := commands.Keys([]string{"add", "-id", "another", "-pubfile", anotherPublicKey, "-keyfile", oneKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = commands.Keys([]string{"remove", "-id", "another", "-keyfile", oneKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = revealWith(t, anotherKey)
+	if err == nil {
+		t.Fatal("removed key can still reveal")
+	}
+
+	err = commands.Reveal([]string{"-keyfile", oneKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testEnvelopeInterruptedRotationKeepsFilesReadable(t *testing.T) {
+	setupEnvelope(t)
+	makeFile("second", t)
+	addAndHide(t, "second")
+
+	err := commands.Keys([]string{"add", "-id", "another", "-pubfile", anotherPublicKey, "-keyfile", oneKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Re-encrypting the second file fails, after the first one is done
+	err = os.Rename("second.private", "second.saved")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Mkdir("second.private", 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = commands.Keys([]string{"remove", "-id", "another", "-keyfile", oneKey}, func() {})
+	if err == nil {
+		t.Fatal("rotation should fail")
+	}
+	err = os.Remove("second.private")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Rename("second.saved", "second.private")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	revealAll := func() {
+		for _, file := range []string{"secret", "second"} {
+			err := os.Remove(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err := commands.Reveal([]string{"-keyfile", oneKey}, func() {})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	revealAll()
+
+	err = commands.Reencrypt([]string{"-keyfile", oneKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revealAll()
+
+	err = revealWith(t, anotherKey)
+	if err == nil {
+		t.Fatal("removed key can still reveal")
+	}
+}
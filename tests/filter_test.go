@@ -0,0 +1,209 @@
+package tests
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/erkkah/git-private/commands"
+)
+
+func TestFilter(t *testing.T) {
+	runAll(Suite{
+		name: "filter", tests: []NamedTest{
+			{"install", testFilterInstallWorks},
+			{"round trip", testFilterRoundTripWorks},
+			{"unchanged is stable", testFilterCleanUnchangedIsStable},
+			{"smudge without key", testFilterSmudgeWithoutKeyPassesThrough},
+			{"new key re-encrypts", testFilterCleanReEncryptsForNewKey},
+			{"passphrase key", testFilterCleanFailsForPassphraseKey},
+		},
+	}, t)
+}
+
+func withStdio(input []byte, command func() error, t *testing.T) []byte {
+	inFile, err := os.CreateTemp(t.TempDir(), "stdin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inFile.Close()
+	_, err = inFile.Write(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = inFile.Seek(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outFile, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer outFile.Close()
+
+	stdin, stdout := os.Stdin, os.Stdout
+	os.Stdin, os.Stdout = inFile, outFile
+	err = command()
+	os.Stdin, os.Stdout = stdin, stdout
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := os.ReadFile(outFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return output
+}
+
+func setupFilterKey(t *testing.T) {
+	addTestKey(t)
+	t.Setenv("GIT_PRIVATE_KEYFILE", oneKey)
+}
+
+func testFilterInstallWorks(t *testing.T) {
+	err := commands.FilterInstall([]string{"*.secret"}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attributes, err := os.ReadFile(".gitattributes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(attributes), "*.secret filter=gitprivate") {
+		t.Fatal("filter attribute not written")
+	}
+
+	config, err := exec.Command("git", "config", "filter.gitprivate.clean").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(config)) != "git-private filter-clean %f" {
+		t.Fatalf("unexpected clean filter config %q", config)
+	}
+}
+
+func testFilterRoundTripWorks(t *testing.T) {
+	setupFilterKey(t)
+
+	plain := []byte("top secret")
+	encrypted := withStdio(plain, func() error {
+		return commands.FilterClean([]string{"file.secret"}, func() {})
+	}, t)
+	if bytes.Contains(encrypted, plain) {
+		t.Fatal("clean filter did not encrypt")
+	}
+
+	decrypted := withStdio(encrypted, func() error {
+		return commands.FilterSmudge([]string{"file.secret"}, func() {})
+	}, t)
+	if !bytes.Equal(decrypted, plain) {
+		t.Fatal("smudge filter did not restore original contents")
+	}
+}
+
+func testFilterCleanUnchangedIsStable(t *testing.T) {
+	setupFilterKey(t)
+
+	plain := []byte("top secret")
+	encrypted := withStdio(plain, func() error {
+		return commands.FilterClean([]string{"file.secret"}, func() {})
+	}, t)
+
+	err := os.WriteFile("file.secret", encrypted, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = exec.Command("git", "add", "file.secret").Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reEncrypted := withStdio(plain, func() error {
+		return commands.FilterClean([]string{"file.secret"}, func() {})
+	}, t)
+	if !bytes.Equal(encrypted, reEncrypted) {
+		t.Fatal("unchanged contents were re-encrypted")
+	}
+}
+
+func testFilterSmudgeWithoutKeyPassesThrough(t *testing.T) {
+	setupFilterKey(t)
+
+	encrypted := withStdio([]byte("top secret"), func() error {
+		return commands.FilterClean([]string{"file.secret"}, func() {})
+	}, t)
+
+	t.Setenv("GIT_PRIVATE_KEYFILE", anotherKey)
+	output := withStdio(encrypted, func() error {
+		return commands.FilterSmudge([]string{"file.secret"}, func() {})
+	}, t)
+	if !bytes.Equal(output, encrypted) {
+		t.Fatal("smudge without access should pass data through")
+	}
+}
+
+func testFilterCleanReEncryptsForNewKey(t *testing.T) {
+	setupFilterKey(t)
+
+	plain := []byte("top secret")
+	encrypted := withStdio(plain, func() error {
+		return commands.FilterClean([]string{"file.secret"}, func() {})
+	}, t)
+	err := os.WriteFile("file.secret", encrypted, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = exec.Command("git", "add", "file.secret").Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = commands.Keys([]string{"add", "-id", "another", "-pubfile", anotherPublicKey, "-keyfile", oneKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reEncrypted := withStdio(plain, func() error {
+		return commands.FilterClean([]string{"file.secret"}, func() {})
+	}, t)
+	if bytes.Equal(encrypted, reEncrypted) {
+		t.Fatal("contents not re-encrypted to the new key")
+	}
+
+	t.Setenv("GIT_PRIVATE_KEYFILE", anotherKey)
+	decrypted := withStdio(reEncrypted, func() error {
+		return commands.FilterSmudge([]string{"file.secret"}, func() {})
+	}, t)
+	if !bytes.Equal(decrypted, plain) {
+		t.Fatal("new key cannot decrypt")
+	}
+}
+
+func testFilterCleanFailsForPassphraseKey(t *testing.T) {
+	setupFilterKey(t)
+
+	protectedKey := t.TempDir() + "/protected.key"
+	err := os.WriteFile(protectedKey, []byte("age-encryption.org/v1\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GIT_PRIVATE_KEYFILE", protectedKey)
+
+	var cleanErr error
+	output := withStdio([]byte("top secret"), func() error {
+		cleanErr = commands.FilterClean([]string{"file.secret"}, func() {})
+		return nil
+	}, t)
+	if cleanErr == nil {
+		t.Fatal("clean with passphrase protected key should fail")
+	}
+	if len(output) != 0 {
+		t.Fatalf("unexpected filter output %q", output)
+	}
+}
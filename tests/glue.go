@@ -5,6 +5,7 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"strings"
 	"testing"
 
 	"github.com/erkkah/git-private/commands"
@@ -81,6 +82,58 @@ func makeFile(name string, t *testing.T) {
 	}
 }
 
+// addTestKey adds the public key of oneKey, unless already added.
+func addTestKey(t *testing.T) {
+	err := commands.Keys([]string{"add", "-id", "test", "-pubfile", onePublicKey, "-keyfile", oneKey}, func() {})
+	if err != nil && !strings.Contains(err.Error(), "already exists") {
+		t.Fatal(err)
+	}
+}
+
+// addAndHide adds a key, and adds and hides the given files using it.
+// Flags to 'add' can be given before the files.
+func addAndHide(t *testing.T, files ...string) {
+	addTestKey(t)
+	err := commands.Add(files, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = commands.Hide([]string{"-keyfile", oneKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+var toolDir string
+
+// installTool builds the tool and puts it first in PATH, for tests where
+// git runs it as a driver or hook.
+func installTool(t *testing.T) {
+	if toolDir == "" {
+		dir, err := os.MkdirTemp("", "git-private-tool")
+		if err != nil {
+			t.Fatal(err)
+		}
+		build := exec.Command("go", "build", "-o", path.Join(dir, "git-private"), "github.com/erkkah/git-private")
+		build.Dir = cwd
+		output, err := build.CombinedOutput()
+		if err != nil {
+			t.Fatalf("failed to build tool: %v\n%s", err, output)
+		}
+		toolDir = dir
+	}
+	t.Setenv("PATH", toolDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func runGit(t *testing.T, args ...string) string {
+	git := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+	output, err := git.Output()
+	if err != nil {
+		t.Fatalf("git %v failed: %v", args, err)
+	}
+	return strings.TrimSpace(string(output))
+}
+
 func runAll(suite Suite, t *testing.T) {
 	for _, test := range suite.tests {
 		setupAndInit(t)
@@ -0,0 +1,103 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/erkkah/git-private/commands"
+	"github.com/erkkah/git-private/utils"
+)
+
+func TestHashing(t *testing.T) {
+	runAll(Suite{
+		name: "hashing", tests: []NamedTest{
+			{"keyed hash", testHideUsesKeyedHash},
+			{"migration", testHideMigratesPlainHashes},
+			{"key removal", testRemovingKeyRotatesHashKey},
+		},
+	}, t)
+}
+
+func hiddenEntry(t *testing.T) (utils.FileList, utils.SecureFile) {
+	list, err := utils.LoadFileList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, found := list.FindFile("secret")
+	if !found {
+		t.Fatal("file not in file list")
+	}
+	return list, entry
+}
+
+func testHideUsesKeyedHash(t *testing.T) {
+	setupHiddenFile(t)
+
+	list, entry := hiddenEntry(t)
+	if list.Version != utils.KeyedHashVersion {
+		t.Fatalf("expected file list version %v, got %v", utils.KeyedHashVersion, list.Version)
+	}
+
+	plainHash, err := utils.GetFileHash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Hash == plainHash {
+		t.Fatal("file list contains plain hash")
+	}
+
+	err = commands.Status(nil, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testHideMigratesPlainHashes(t *testing.T) {
+	setupHiddenFile(t)
+
+	// Turn the file list into a version 1 list
+	list, entry := hiddenEntry(t)
+	plainHash, err := utils.GetFileHash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.Hash = plainHash
+	err = list.UpdateFile(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list.Version = 1
+	err = utils.StoreFileList(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hideAndRead(t)
+
+	list, entry = hiddenEntry(t)
+	if list.Version != utils.KeyedHashVersion {
+		t.Fatal("file list was not migrated")
+	}
+	if entry.Hash == plainHash {
+		t.Fatal("plain hash was not replaced")
+	}
+}
+
+func testRemovingKeyRotatesHashKey(t *testing.T) {
+	setupHiddenFile(t)
+
+	err := commands.Keys([]string{"add", "-id", "another", "-pubfile", anotherPublicKey, "-keyfile", oneKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, before := hiddenEntry(t)
+
+	err = commands.Keys([]string{"remove", "-id", "another", "-keyfile", oneKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, after := hiddenEntry(t)
+
+	if before.Hash == after.Hash {
+		t.Fatal("hash key was not replaced")
+	}
+}
@@ -0,0 +1,76 @@
+package tests
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/erkkah/git-private/commands"
+)
+
+func TestHide(t *testing.T) {
+	runAll(Suite{
+		name: "hide", tests: []NamedTest{
+			{"unchanged file", testHideUnchangedFileKeepsPrivateFile},
+			{"forced", testHideForcedReEncrypts},
+			{"new key", testHideAfterAddingKeyReEncrypts},
+		},
+	}, t)
+}
+
+func hideAndRead(t *testing.T, args ...string) []byte {
+	err := commands.Hide(append([]string{"-keyfile", oneKey}, args...), func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	private, err := os.ReadFile("secret.private")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return private
+}
+
+func setupHiddenFile(t *testing.T) []byte {
+	makeFile("secret", t)
+	addAndHide(t, "secret")
+	private, err := os.ReadFile("secret.private")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return private
+}
+
+func testHideUnchangedFileKeepsPrivateFile(t *testing.T) {
+	first := setupHiddenFile(t)
+	second := hideAndRead(t)
+
+	if !bytes.Equal(first, second) {
+		t.Fatal("unchanged file was re-encrypted")
+	}
+}
+
+func testHideForcedReEncrypts(t *testing.T) {
+	first := setupHiddenFile(t)
+	second := hideAndRead(t, "-force")
+
+	if bytes.Equal(first, second) {
+		t.Fatal("forced hide did not re-encrypt")
+	}
+}
+
+func testHideAfterAddingKeyReEncrypts(t *testing.T) {
+	first := setupHiddenFile(t)
+
+	err := commands.Keys([]string{"add", "-id", "another", "-pubfile", anotherPublicKey, "-keyfile", oneKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := os.ReadFile("secret.private")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(first, second) {
+		t.Fatal("file was not re-encrypted to the new key")
+	}
+}
@@ -0,0 +1,138 @@
+package tests
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/erkkah/git-private/commands"
+)
+
+func TestScanHistory(t *testing.T) {
+	runAll(Suite{
+		name: "scan-history", tests: []NamedTest{
+			{"hidden files pass", testScanHistoryHiddenFilesPass},
+			{"plain text found", testScanHistoryPlainTextFound},
+			{"json", testScanHistoryJSON},
+			{"subdirectory", testScanHistorySubdirectory},
+			{"merge commit", testScanHistoryMergeCommit},
+		},
+	}, t)
+}
+
+type historyFinding struct {
+	Commit string
+	Path   string
+}
+
+// scanHistory runs scan-history in JSON mode, returning the findings
+// and the error returned by the command.
+func scanHistory(t *testing.T) ([]historyFinding, error) {
+	outFile, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer outFile.Close()
+
+	stdout := os.Stdout
+	os.Stdout = outFile
+	scanErr := commands.ScanHistory([]string{"-json"}, func() {})
+	os.Stdout = stdout
+
+	output, err := os.ReadFile(outFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var findings []historyFinding
+	err = json.Unmarshal(output, &findings)
+	if err != nil {
+		t.Fatalf("invalid JSON output %q: %v", output, err)
+	}
+	return findings, scanErr
+}
+
+func commitPlainText(t *testing.T) string {
+	runGit(t, "add", "-f", "secret")
+	runGit(t, "commit", "-m", "oops")
+	return runGit(t, "rev-parse", "HEAD")
+}
+
+func testScanHistoryHiddenFilesPass(t *testing.T) {
+	setupHiddenCommit(t)
+
+	err := commands.ScanHistory([]string{}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testScanHistoryPlainTextFound(t *testing.T) {
+	setupHiddenCommit(t)
+	commitPlainText(t)
+
+	err := commands.ScanHistory([]string{}, func() {})
+	if err == nil {
+		t.Fatal("plain text commit should be reported")
+	}
+}
+
+func testScanHistoryJSON(t *testing.T) {
+	setupHiddenCommit(t)
+
+	findings, err := scanHistory(t)
+	if err != nil || len(findings) != 0 {
+		t.Fatalf("unexpected findings %v, %v", findings, err)
+	}
+
+	commit := commitPlainText(t)
+
+	findings, err = scanHistory(t)
+	if err == nil {
+		t.Fatal("plain text commit should fail also in JSON mode")
+	}
+	if len(findings) != 1 || findings[0].Commit != commit || findings[0].Path != "secret" {
+		t.Fatalf("unexpected findings %v", findings)
+	}
+}
+
+func testScanHistorySubdirectory(t *testing.T) {
+	setupHiddenCommit(t)
+	commitPlainText(t)
+
+	err := os.Mkdir("sub", 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir("sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir("..")
+
+	findings, err := scanHistory(t)
+	if err == nil || len(findings) != 1 {
+		t.Fatalf("plain text commit not found from subdirectory: %v", findings)
+	}
+}
+
+func testScanHistoryMergeCommit(t *testing.T) {
+	setupHiddenCommit(t)
+
+	runGit(t, "checkout", "-q", "-b", "side")
+	makeFile("side.txt", t)
+	runGit(t, "add", "side.txt")
+	runGit(t, "commit", "-m", "side")
+	runGit(t, "checkout", "-q", "-")
+	makeFile("main.txt", t)
+	runGit(t, "add", "main.txt")
+	runGit(t, "commit", "-m", "main")
+
+	// The plain text is only introduced by the merge commit
+	runGit(t, "merge", "-q", "--no-commit", "side")
+	merge := commitPlainText(t)
+
+	findings, err := scanHistory(t)
+	if err == nil || len(findings) != 1 || findings[0].Commit != merge {
+		t.Fatalf("plain text in merge commit not found: %v", findings)
+	}
+}
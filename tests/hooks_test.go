@@ -0,0 +1,173 @@
+package tests
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/erkkah/git-private/commands"
+)
+
+func TestHooks(t *testing.T) {
+	runAll(Suite{
+		name: "hooks", tests: []NamedTest{
+			{"install", testHooksInstall},
+			{"existing hook", testHooksChainExistingHook},
+			{"reinstall", testHooksReinstall},
+			{"uninstall", testHooksUninstall},
+			{"initial checkout", testHooksSkipInitialCheckout},
+			{"not initialized", testHooksRunWithoutState},
+		},
+	}, t)
+}
+
+const existingHook = "#!/usr/bin/env python3\nopen('hook-ran', 'w').write('yes')\n"
+
+func hookPath(hook string) string {
+	return filepath.Join(".git", "hooks", hook)
+}
+
+func writeExistingHook(t *testing.T, hook string) {
+	err := os.MkdirAll(filepath.Join(".git", "hooks"), 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(hookPath(hook), []byte(existingHook), 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readHook(t *testing.T, file string) string {
+	contents, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(contents)
+}
+
+func testHooksInstall(t *testing.T) {
+	err := commands.Hooks([]string{"install"}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, hook := range []string{"pre-commit", "post-merge", "post-checkout"} {
+		info, err := os.Stat(hookPath(hook))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode()&0100 == 0 {
+			t.Fatalf("%s hook not executable", hook)
+		}
+		contents := readHook(t, hookPath(hook))
+		if !strings.HasPrefix(contents, "#!/bin/sh\n") || !strings.Contains(contents, "git-private hooks run "+hook) {
+			t.Fatalf("unexpected %s hook:\n%s", hook, contents)
+		}
+	}
+}
+
+func testHooksChainExistingHook(t *testing.T) {
+	writeExistingHook(t, "pre-commit")
+
+	err := commands.Hooks([]string{"install"}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if chained := readHook(t, hookPath("pre-commit.git-private-orig")); chained != existingHook {
+		t.Fatalf("existing hook not kept:\n%s", chained)
+	}
+	if contents := readHook(t, hookPath("pre-commit")); strings.Contains(contents, "python") {
+		t.Fatalf("existing hook mixed into managed hook:\n%s", contents)
+	}
+}
+
+func testHooksReinstall(t *testing.T) {
+	writeExistingHook(t, "pre-commit")
+
+	err := commands.Hooks([]string{"install"}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = commands.Hooks([]string{"install", "-autohide"}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contents := readHook(t, hookPath("pre-commit"))
+	if strings.Count(contents, "git-private hooks run") != 1 || !strings.Contains(contents, "-autohide") {
+		t.Fatalf("unexpected reinstalled hook:\n%s", contents)
+	}
+	if chained := readHook(t, hookPath("pre-commit.git-private-orig")); chained != existingHook {
+		t.Fatalf("existing hook not kept:\n%s", chained)
+	}
+}
+
+func testHooksUninstall(t *testing.T) {
+	writeExistingHook(t, "pre-commit")
+
+	err := commands.Hooks([]string{"install"}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = commands.Hooks([]string{"uninstall"}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if contents := readHook(t, hookPath("pre-commit")); contents != existingHook {
+		t.Fatalf("existing hook not restored:\n%s", contents)
+	}
+	for _, file := range []string{"pre-commit.git-private-orig", "post-merge", "post-checkout"} {
+		if _, err := os.Stat(hookPath(file)); err == nil {
+			t.Fatalf("%s left after uninstall", file)
+		}
+	}
+}
+
+func testHooksSkipInitialCheckout(t *testing.T) {
+	installTool(t)
+
+	err := os.MkdirAll(filepath.Join(".git", "hooks"), 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(hookPath("post-checkout"), []byte("#!/bin/sh\ntouch hook-ran\n"), 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = commands.Hooks([]string{"install"}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hook := exec.Command(hookPath("post-checkout"), strings.Repeat("0", 40), "HEAD", "1")
+	output, err := hook.CombinedOutput()
+	if err != nil {
+		t.Fatalf("post-checkout hook failed: %v\n%s", err, output)
+	}
+	if _, err := os.Stat("hook-ran"); err != nil {
+		t.Fatal("existing hook not run")
+	}
+}
+
+func testHooksRunWithoutState(t *testing.T) {
+	installTool(t)
+
+	err := commands.Hooks([]string{"install"}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Like a branch where git-private is not used
+	err = os.RemoveAll(".gitprivate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	makeFile("plain.txt", t)
+	runGit(t, "add", "plain.txt")
+	runGit(t, "commit", "-m", "plain")
+}
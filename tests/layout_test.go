@@ -0,0 +1,150 @@
+package tests
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/erkkah/git-private/commands"
+)
+
+func TestBlobLayout(t *testing.T) {
+	runAll(Suite{
+		name: "blobs", tests: []NamedTest{
+			{"hide", testBlobLayoutHidesFileNames},
+			{"reveal", testBlobLayoutRevealsInClone},
+			{"patterns in clone", testBlobLayoutIgnoresPatternsInClone},
+		},
+	}, t)
+}
+
+func setupBlobLayout(t *testing.T) []byte {
+	err := os.RemoveAll(".gitprivate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Remove(".gitignore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = commands.Init([]string{"-layout", "blobs"}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	makeFile("secret", t)
+	addAndHide(t, "secret")
+
+	plain, err := os.ReadFile("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return plain
+}
+
+func testBlobLayoutHidesFileNames(t *testing.T) {
+	setupBlobLayout(t)
+
+	if _, err := os.Stat("secret.private"); err == nil {
+		t.Fatal("private file stored next to original file")
+	}
+
+	blobs, err := filepath.Glob(".gitprivate/blobs/*.age")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blobs) != 1 {
+		t.Fatalf("expected one blob, found %v", len(blobs))
+	}
+
+	for _, file := range []string{".gitprivate/index.dat", ".gitprivate/settings.json", ".gitignore"} {
+		data, err := os.ReadFile(file)
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+		if bytes.Contains(data, []byte("secret")) {
+			t.Fatalf("file name visible in %q", file)
+		}
+	}
+
+	status := runGit(t, "status", "--porcelain", "--untracked-files=all")
+	if strings.Contains(status, "secret") {
+		t.Fatal("revealed file not ignored")
+	}
+}
+
+func testBlobLayoutRevealsInClone(t *testing.T) {
+	plain := setupBlobLayout(t)
+
+	// Drop local state, as in a fresh clone
+	gitDir := runGit(t, "rev-parse", "--git-dir")
+	err := os.RemoveAll(filepath.Join(gitDir, "git-private"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Remove("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = commands.Status(nil, func() {})
+	if err == nil {
+		t.Fatal("file list readable without key")
+	}
+
+	err = commands.Reveal([]string{"-keyfile", oneKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revealed, err := os.ReadFile("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plain, revealed) {
+		t.Fatal("revealed file differs")
+	}
+}
+
+func testBlobLayoutIgnoresPatternsInClone(t *testing.T) {
+	setupBlobLayout(t)
+	makeFile("first.pem", t)
+	err := commands.Add([]string{"*.pem"}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = commands.Hide([]string{"-keyfile", oneKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, "add", "-A")
+	runGit(t, "commit", "-m", "hidden")
+
+	key, err := filepath.Abs(oneKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clone := filepath.Join(t.TempDir(), "clone")
+	runGit(t, "clone", "-q", source, clone)
+	err = os.Chdir(clone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(source)
+
+	err = commands.Reveal([]string{"-keyfile", key}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	makeFile("second.pem", t)
+	status := runGit(t, "status", "--porcelain", "--untracked-files=all")
+	if strings.Contains(status, ".pem") {
+		t.Fatalf("file matching tracked pattern not ignored in clone:\n%s", status)
+	}
+}
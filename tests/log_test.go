@@ -0,0 +1,187 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/erkkah/git-private/commands"
+	"github.com/erkkah/git-private/utils"
+)
+
+func TestLog(t *testing.T) {
+	runAll(Suite{
+		name: "log", tests: []NamedTest{
+			{"redacted", testLogRedacted},
+			{"unredacted", testLogUnredacted},
+			{"diff", testDiffLines},
+			{"moved", testLogMoved},
+			{"rotated data key", testLogRotatedDataKey},
+			{"subdirectory", testLogSubdirectory},
+		},
+	}, t)
+}
+
+func commitSecretHistory(t *testing.T) {
+	hideStructured(t, "config.json", jsonSample, "whole")
+	runGit(t, "add", "-A")
+	runGit(t, "commit", "-m", "first")
+
+	updated := strings.Replace(jsonSample, "correct horse", "battery staple", 1)
+	err := os.WriteFile("config.json", []byte(updated), 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = commands.Hide([]string{"-keyfile", oneKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, "add", "-A")
+	runGit(t, "commit", "-m", "second")
+}
+
+func logFile(t *testing.T, args ...string) string {
+	output := withStdio(nil, func() error {
+		return commands.Log(append([]string{"-keyfile", oneKey}, args...), func() {})
+	}, t)
+	return string(output)
+}
+
+func testLogRedacted(t *testing.T) {
+	commitSecretHistory(t)
+
+	output := logFile(t, "config.json")
+	if strings.Count(output, "\ncommit ") != 1 || !strings.HasPrefix(output, "commit ") {
+		t.Fatalf("expected two commits:\n%s", output)
+	}
+	for _, expected := range []string{
+		"--- a/config.json\n+++ b/config.json\n@@ -1,7 +1,7 @@\n",
+		`-    "password": ***,`,
+		`+    "password": ***,`,
+		"--- /dev/null\n",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Fatalf("expected %q in log:\n%s", expected, output)
+		}
+	}
+	for _, secret := range []string{"correct horse", "battery staple", "db.example.com"} {
+		if strings.Contains(output, secret) {
+			t.Fatalf("value %q visible in log:\n%s", secret, output)
+		}
+	}
+}
+
+func testLogUnredacted(t *testing.T) {
+	commitSecretHistory(t)
+
+	output := logFile(t, "config.json", "-unredacted")
+	for _, expected := range []string{
+		`-    "password": "correct horse",`,
+		`+    "password": "battery staple",`,
+		"+  \"debug\": false\n",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Fatalf("expected %q in log:\n%s", expected, output)
+		}
+	}
+}
+
+func testDiffLines(t *testing.T) {
+	diff := utils.DiffLines(
+		[]string{"a", "b", "c", "d", "e"},
+		[]string{"a", "c", "x", "d", "e", "f"},
+	)
+	var kinds []byte
+	for _, line := range diff {
+		kinds = append(kinds, line.Kind)
+	}
+	if string(kinds) != " - +  +" {
+		t.Fatalf("unexpected diff %q", kinds)
+	}
+}
+
+func testLogMoved(t *testing.T) {
+	commitSecretHistory(t)
+
+	err := commands.Move([]string{"config.json", "settings.json"}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, "add", "-A")
+	runGit(t, "commit", "-m", "moved")
+
+	err = commands.Relayout([]string{"mirror"}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, "add", "-A")
+	runGit(t, "commit", "-m", "relayout")
+
+	output := logFile(t, "settings.json", "-unredacted")
+	if strings.Count(output, "commit ") != 4 {
+		t.Fatalf("expected four commits:\n%s", output)
+	}
+	for _, expected := range []string{
+		"--- a/config.json\n+++ b/config.json\n",
+		`+    "password": "battery staple",`,
+		"--- /dev/null\n+++ b/config.json\n",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Fatalf("expected %q in log:\n%s", expected, output)
+		}
+	}
+	if strings.Contains(output, "cannot decrypt") {
+		t.Fatalf("versions before move not decrypted:\n%s", output)
+	}
+}
+
+func testLogRotatedDataKey(t *testing.T) {
+	setupEnvelope(t)
+	runGit(t, "add", "-A")
+	runGit(t, "commit", "-m", "envelope")
+
+	err := commands.Keys([]string{"add", "-id", "another", "-pubfile", anotherPublicKey, "-keyfile", oneKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = commands.Keys([]string{"remove", "-id", "another", "-keyfile", oneKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, "add", "-A")
+	runGit(t, "commit", "-m", "rotated")
+
+	output := logFile(t, "secret")
+	if strings.Count(output, "commit ") != 2 {
+		t.Fatalf("expected two commits:\n%s", output)
+	}
+	if strings.Contains(output, "cannot decrypt") {
+		t.Fatalf("versions before rotation not decrypted:\n%s", output)
+	}
+}
+
+func testLogSubdirectory(t *testing.T) {
+	commitSecretHistory(t)
+
+	key, err := filepath.Abs(oneKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Mkdir("sub", 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir("sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir("..")
+
+	output := string(withStdio(nil, func() error {
+		return commands.Log([]string{"-keyfile", key, "../config.json"}, func() {})
+	}, t))
+	if strings.Count(output, "commit ") != 2 {
+		t.Fatalf("expected two commits:\n%s", output)
+	}
+}
@@ -0,0 +1,240 @@
+package tests
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+
+	"github.com/erkkah/git-private/commands"
+	"github.com/erkkah/git-private/utils"
+)
+
+func TestMerge(t *testing.T) {
+	runAll(Suite{
+		name: "merge", tests: []NamedTest{
+			{"private file", testMergePrivateFile},
+			{"private file conflict", testMergePrivateFileConflict},
+			{"file lists", testMergeFileLists},
+			{"key lists", testMergeKeyLists},
+			{"envelope keys", testMergeEnvelopeKeys},
+		},
+	}, t)
+}
+
+// setupMerge hides the given file, commits it and branches off "other",
+// staying on the main branch. Files are added as a pattern, to keep
+// ignore rules from conflicting.
+func setupMerge(t *testing.T, file string, contents string) string {
+	installTool(t)
+	key, err := filepath.Abs(oneKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GIT_PRIVATE_KEYFILE", key)
+
+	err = commands.MergeInstall([]string{}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(file, []byte(contents), 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addAndHide(t, "*.txt")
+	runGit(t, "add", "-A")
+	runGit(t, "commit", "-m", "base")
+
+	main := runGit(t, "rev-parse", "--abbrev-ref", "HEAD")
+	runGit(t, "branch", "other")
+	return main
+}
+
+// hideAndCommit writes and hides a file matching "*.txt", and commits on the given branch.
+func hideAndCommit(t *testing.T, branch string, file string, contents string) {
+	runGit(t, "checkout", "-q", branch)
+	err := os.WriteFile(file, []byte(contents), 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Covered by the pattern added by setupMerge. Checkouts update private
+	// files without revealing them, so hiding needs to be forced.
+	err = commands.Hide([]string{"-force", file}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, "add", "-A")
+	runGit(t, "commit", "-m", "update "+file)
+}
+
+func testMergePrivateFile(t *testing.T) {
+	main := setupMerge(t, "secret.txt", "first\nsecond\nthird\n")
+	hideAndCommit(t, "other", "secret.txt", "first\nsecond\nthird, changed\n")
+	hideAndCommit(t, main, "secret.txt", "first, changed\nsecond\nthird\n")
+
+	runGit(t, "merge", "-q", "-m", "merge", "other")
+
+	err := commands.Reveal([]string{}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revealed, err := os.ReadFile("secret.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(revealed) != "first, changed\nsecond\nthird, changed\n" {
+		t.Fatalf("unexpected merge result %q", revealed)
+	}
+	if output := catFile(t, "secret.txt"); output != string(revealed) {
+		t.Fatalf("private file not in sync, got %q", output)
+	}
+
+	err = commands.Hide([]string{}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, "add", "-A")
+	runGit(t, "commit", "-m", "hidden")
+	if status := runGit(t, "status", "--porcelain"); status != "" {
+		t.Fatalf("unexpected changes after hiding merged file:\n%s", status)
+	}
+}
+
+func testMergePrivateFileConflict(t *testing.T) {
+	main := setupMerge(t, "secret.txt", "first\n")
+	hideAndCommit(t, "other", "secret.txt", "theirs\n")
+	hideAndCommit(t, main, "secret.txt", "ours\n")
+
+	merge := exec.Command("git", "-c", "user.name=test", "-c", "user.email=test@example.com", "merge", "-m", "merge", "other")
+	output, err := merge.CombinedOutput()
+	if err == nil {
+		t.Fatal("merge of conflicting changes should fail")
+	}
+	if !strings.Contains(string(output), "resolve in revealed file") {
+		t.Fatalf("unexpected merge output:\n%s", output)
+	}
+
+	revealed, err := os.ReadFile("secret.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"<<<<<<<", "ours\n", "theirs\n", ">>>>>>>"} {
+		if !strings.Contains(string(revealed), expected) {
+			t.Fatalf("expected %q in revealed file:\n%s", expected, revealed)
+		}
+	}
+}
+
+func testMergeFileLists(t *testing.T) {
+	main := setupMerge(t, "secret.txt", "secret\n")
+	hideAndCommit(t, "other", "theirs.txt", "theirs\n")
+	hideAndCommit(t, main, "ours.txt", "ours\n")
+
+	runGit(t, "merge", "-q", "-m", "merge", "other")
+
+	fileList, err := utils.LoadFileList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []utils.RepoRelativePath{"secret.txt", "theirs.txt", "ours.txt"} {
+		if _, found := fileList.FindFile(file); !found {
+			t.Fatalf("%q missing from merged file list", file)
+		}
+	}
+	if output := catFile(t, "theirs.txt"); output != "theirs\n" {
+		t.Fatalf("unexpected contents %q", output)
+	}
+}
+
+func testMergeKeyLists(t *testing.T) {
+	main := setupMerge(t, "secret.txt", "secret\n")
+
+	runGit(t, "checkout", "-q", "other")
+	err := commands.Keys([]string{"add", "-id", "another", "-pubfile", anotherPublicKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, "add", "-A")
+	runGit(t, "commit", "-m", "add key")
+
+	hideAndCommit(t, main, "ours.txt", "ours\n")
+
+	runGit(t, "merge", "-q", "-m", "merge", "other")
+
+	// Hidden on the main branch, before the key was merged
+	err = commands.Cat([]string{"-keyfile", anotherKey, "ours.txt"}, func() {})
+	if err == nil {
+		t.Fatal("file encrypted to a key that was not added yet")
+	}
+
+	err = commands.Reencrypt([]string{}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := withStdio(nil, func() error {
+		return commands.Cat([]string{"-keyfile", anotherKey, "ours.txt"}, func() {})
+	}, t)
+	if string(output) != "ours\n" {
+		t.Fatalf("unexpected contents %q", output)
+	}
+}
+
+func testMergeEnvelopeKeys(t *testing.T) {
+	main := setupMerge(t, "secret.txt", "secret\n")
+	err := commands.Envelope([]string{"enable"}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, "add", "-A")
+	runGit(t, "commit", "-m", "envelope")
+	runGit(t, "branch", "-f", "other")
+
+	err = commands.Hooks([]string{"install"}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The data key is encrypted to a new key on each branch
+	runGit(t, "checkout", "-q", "other")
+	err = commands.Keys([]string{"add", "-id", "another", "-pubfile", anotherPublicKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, "add", "-A")
+	runGit(t, "commit", "-m", "add another key")
+
+	runGit(t, "checkout", "-q", main)
+	third, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile("third.key", []byte(third.String()+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile("third.pub", []byte(third.Recipient().String()+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = commands.Keys([]string{"add", "-id", "third", "-pubfile", "third.pub"}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, "add", "-A")
+	runGit(t, "commit", "-m", "add third key")
+
+	runGit(t, "merge", "-q", "-m", "merge", "other")
+
+	for _, key := range []string{anotherKey, "third.key"} {
+		output := withStdio(nil, func() error {
+			return commands.Cat([]string{"-keyfile", key, "secret.txt"}, func() {})
+		}, t)
+		if string(output) != "secret\n" {
+			t.Fatalf("unexpected contents %q", output)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package tests
+
+import (
+	"os"
+	"testing"
+
+	"github.com/erkkah/git-private/commands"
+	"github.com/erkkah/git-private/utils"
+)
+
+func TestMove(t *testing.T) {
+	runAll(Suite{
+		name: "mv", tests: []NamedTest{
+			{"untracked file", testMoveUntrackedFileFails},
+			{"hidden file", testMoveHiddenFileWorks},
+			{"directory", testMoveDirectoryWorks},
+			{"failed move", testMoveFailureKeepsFileIgnored},
+		},
+	}, t)
+}
+
+func testMoveUntrackedFileFails(t *testing.T) {
+	makeFile("untracked", t)
+
+	err := commands.Move([]string{"untracked", "moved"}, func() {})
+	if err == nil {
+		t.Fatal("moving untracked file should fail")
+	}
+}
+
+func testMoveHiddenFileWorks(t *testing.T) {
+	makeFile("secret", t)
+	addAndHide(t, "secret")
+
+	before, err := os.ReadFile("secret.private")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = commands.Move([]string{"secret", "moved"}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := os.ReadFile("moved.private")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(before) != string(after) {
+		t.Fatal("private file was re-encrypted")
+	}
+
+	list, err := utils.LoadFileList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Files) != 1 || list.Files[0].Path != "moved" || list.Files[0].Hash == "" {
+		t.Fatalf("unexpected file list: %+v", list)
+	}
+
+	ignored, err := utils.IsGitIgnored("moved")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ignored {
+		t.Fatal("moved file is not ignored")
+	}
+}
+
+func testMoveDirectoryWorks(t *testing.T) {
+	err := os.Mkdir("secrets", 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+	makeFile("secrets/one", t)
+	addAndHide(t, "secrets")
+
+	err = commands.Move([]string{"secrets", "moved"}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range []string{"moved/one", "moved/one.private"} {
+		exists, err := utils.Exists(utils.AbsolutePath(file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !exists {
+			t.Fatalf("%q was not moved", file)
+		}
+	}
+
+	list, err := utils.LoadFileList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Patterns) != 1 || list.Patterns[0].Pattern != "moved/**" ||
+		len(list.Patterns[0].Files) != 1 || list.Patterns[0].Files[0].Path != "moved/one" {
+		t.Fatalf("unexpected file list: %+v", list)
+	}
+}
+
+func testMoveFailureKeepsFileIgnored(t *testing.T) {
+	makeFile("secret", t)
+	addAndHide(t, "secret")
+
+	// Creating the destination directory fails
+	err := os.Symlink("missing", "link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = commands.Move([]string{"secret", "link/secret"}, func() {})
+	if err == nil {
+		t.Fatal("move should fail")
+	}
+
+	ignored, err := utils.IsGitIgnored("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ignored {
+		t.Fatal("file is no longer ignored after failed move")
+	}
+
+	list, err := utils.LoadFileList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Files) != 1 || list.Files[0].Path != "secret" {
+		t.Fatalf("unexpected file list: %+v", list)
+	}
+}
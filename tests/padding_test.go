@@ -0,0 +1,130 @@
+package tests
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/erkkah/git-private/commands"
+	"github.com/erkkah/git-private/utils"
+)
+
+func TestPadding(t *testing.T) {
+	runAll(Suite{
+		name: "padding", tests: []NamedTest{
+			{"payload", testPaddedPayloadRoundTrip},
+			{"sizes", testPaddingHidesFileSizes},
+			{"unpadded", testUnpaddedFilesStillReveal},
+		},
+	}, t)
+}
+
+func testPaddedPayloadRoundTrip(t *testing.T) {
+	plain := []byte("short secret")
+
+	for _, padding := range []utils.Padding{utils.NoPadding, utils.PowerOfTwoPadding, utils.BucketPadding} {
+		payload, err := utils.EncodePayload(plain, false, padding)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if padding != utils.NoPadding && len(payload) < 256 {
+			t.Fatalf("%q payload not padded, %d bytes", padding, len(payload))
+		}
+		decoded, err := utils.DecodePayload(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(plain, decoded) {
+			t.Fatalf("%q payload differs after decoding", padding)
+		}
+	}
+}
+
+func testPaddingHidesFileSizes(t *testing.T) {
+	err := commands.Padding([]string{"pow2", "-keyfile", oneKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	makeFile("longer", t)
+	err = os.WriteFile("shorter", []byte("token"), 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addAndHide(t, "longer", "shorter")
+
+	shorter, err := os.Stat("shorter.private")
+	if err != nil {
+		t.Fatal(err)
+	}
+	longer, err := os.Stat("longer.private")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shorter.Size() < 256 || longer.Size() < 8192 {
+		t.Fatalf("private files not padded, %d and %d bytes", shorter.Size(), longer.Size())
+	}
+
+	err = os.Remove("shorter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = commands.Reveal([]string{"-keyfile", oneKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revealed, err := os.ReadFile("shorter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(revealed) != "token" {
+		t.Fatalf("unexpected revealed contents %q", revealed)
+	}
+}
+
+func testUnpaddedFilesStillReveal(t *testing.T) {
+	makeFile("secret", t)
+	addAndHide(t, "secret")
+
+	plain, err := os.ReadFile("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unpadded, err := os.Stat("secret.private")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = commands.Padding([]string{"buckets", "-keyfile", oneKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	padded, err := os.Stat("secret.private")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if padded.Size() <= unpadded.Size() {
+		t.Fatal("private file not padded when padding was set")
+	}
+
+	err = commands.Status([]string{"-keyfile", oneKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Remove("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = commands.Reveal([]string{"-keyfile", oneKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revealed, err := os.ReadFile("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plain, revealed) {
+		t.Fatal("revealed file differs")
+	}
+}
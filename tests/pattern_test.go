@@ -0,0 +1,165 @@
+package tests
+
+import (
+	"os"
+	"testing"
+
+	"github.com/erkkah/git-private/commands"
+	"github.com/erkkah/git-private/utils"
+)
+
+func TestPatterns(t *testing.T) {
+	runAll(Suite{
+		name: "patterns", tests: []NamedTest{
+			{"hide new matching file", testPatternHidesNewMatchingFile},
+			{"add directory", testAddDirectoryAddsPattern},
+			{"remove pattern", testRemovePatternRemovesPrivateFiles},
+			{"bracket pattern", testBracketPatternMatchesLikeGit},
+		},
+	}, t)
+}
+
+func testPatternHidesNewMatchingFile(t *testing.T) {
+	addTestKey(t)
+	makeFile("first.pem", t)
+
+	err := commands.Add([]string{"*.pem"}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = commands.Hide([]string{"-keyfile", oneKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	makeFile("second.pem", t)
+
+	err = commands.Hide([]string{"-keyfile", oneKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range []string{"first.pem.private", "second.pem.private"} {
+		exists, err := utils.Exists(utils.AbsolutePath(file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !exists {
+			t.Fatalf("%q was not hidden", file)
+		}
+	}
+
+	list, err := utils.LoadFileList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Files) != 0 || len(list.Patterns) != 1 || len(list.Patterns[0].Files) != 2 {
+		t.Fatalf("unexpected file list: %+v", list)
+	}
+
+	ignored, err := utils.IsGitIgnored("second.pem.private")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ignored {
+		t.Fatal("private file is ignored")
+	}
+}
+
+func testAddDirectoryAddsPattern(t *testing.T) {
+	err := os.Mkdir("secrets", 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+	makeFile("secrets/one", t)
+
+	err = commands.Add([]string{"secrets"}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := utils.LoadFileList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Patterns) != 1 || list.Patterns[0].Pattern != "secrets/**" {
+		t.Fatalf("unexpected file list: %+v", list)
+	}
+	if !list.Covers("secrets/one") {
+		t.Fatal("file not covered by directory pattern")
+	}
+}
+
+func testRemovePatternRemovesPrivateFiles(t *testing.T) {
+	addTestKey(t)
+	makeFile("first.pem", t)
+
+	err := commands.Add([]string{"*.pem"}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = commands.Hide([]string{"-keyfile", oneKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = commands.Remove([]string{"*.pem"}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exists, err := utils.Exists("first.pem.private")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Fatal("private file left behind")
+	}
+
+	list, err := utils.LoadFileList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Patterns) != 0 {
+		t.Fatal("pattern not removed")
+	}
+}
+
+func testBracketPatternMatchesLikeGit(t *testing.T) {
+	addTestKey(t)
+	for _, file := range []string{"key1.pem", "keyA.pem", "cert1.pem", "certA.pem"} {
+		makeFile(file, t)
+	}
+
+	err := commands.Add([]string{"key[0-9].pem", "cert[!0-9].pem"}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = commands.Hide([]string{"-keyfile", oneKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := utils.LoadFileList()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for file, private := range map[string]bool{"key1.pem": true, "keyA.pem": false, "cert1.pem": false, "certA.pem": true} {
+		// Files ignored by git must be hidden, and the other way around
+		ignored, err := utils.IsGitIgnored(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hidden, err := utils.Exists(utils.AbsolutePath(file + utils.PrivateExtension))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ignored != private || hidden != private || list.Covers(utils.RepoRelativePath(file)) != private {
+			t.Fatalf("%q: ignored %v, hidden %v, expected %v", file, ignored, hidden, private)
+		}
+	}
+}
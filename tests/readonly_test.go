@@ -12,6 +12,7 @@ func TestReadonly(t *testing.T) {
 			{"adding keys fails", testReadonlyAddKeyFails},
 			{"hiding fails", testReadonlyHidingFails},
 			{"reveal succeeds", testReadonlyRevealSucceeds},
+			{"hiding fails in envelope mode", testReadonlyEnvelopeHidingFails},
 		},
 	}, t)
 }
@@ -71,3 +72,31 @@ func testReadonlyRevealSucceeds(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func testReadonlyEnvelopeHidingFails(t *testing.T) {
+	setupKeys(t)
+	makeFile("secret", t)
+
+	err := commands.Add([]string{"secret"}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = commands.Envelope([]string{"enable", "-keyfile", oneKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = commands.Hide([]string{"-keyfile", anotherKey}, func() {})
+	if err == nil {
+		t.Fatal("Hiding with readonly key should fail in envelope mode!")
+	}
+
+	err = commands.Hide([]string{"-keyfile", oneKey, "-clean"}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = commands.Reveal([]string{"-keyfile", anotherKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,231 @@
+package tests
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/erkkah/git-private/commands"
+)
+
+func TestRelayout(t *testing.T) {
+	runAll(Suite{
+		name: "relayout", tests: []NamedTest{
+			{"mirror", testRelayoutToMirror},
+			{"sibling", testRelayoutBackToSibling},
+			{"mirror mv", testMoveInMirrorLayout},
+			{"failed relayout", testRelayoutFailureKeepsLayout},
+			{"merge attributes", testRelayoutMovesMergeAttributes},
+		},
+	}, t)
+}
+
+func testRelayoutToMirror(t *testing.T) {
+	err := os.Mkdir("config", 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+	makeFile(filepath.Join("config", "secret"), t)
+	addAndHide(t, filepath.Join("config", "secret"))
+
+	before, err := os.ReadFile(filepath.Join("config", "secret.private"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = commands.Relayout([]string{"mirror"}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join("config", "secret.private")); err == nil {
+		t.Fatal("private file left next to original file")
+	}
+	after, err := os.ReadFile(filepath.Join(".gitprivate", "store", "config", "secret.age"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Fatal("private file changed by relayout")
+	}
+
+	gitignore, err := os.ReadFile(".gitignore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(gitignore), "!*.private") || !strings.Contains(string(gitignore), "!/.gitprivate/store/**") {
+		t.Fatalf("unexpected .gitignore:\n%s", gitignore)
+	}
+
+	status := runGit(t, "status", "--porcelain", "--untracked-files=all")
+	if !strings.Contains(status, ".gitprivate/store/config/secret.age") {
+		t.Fatal("private file ignored in mirror layout")
+	}
+
+	err = commands.Status([]string{"-keyfile", oneKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testRelayoutBackToSibling(t *testing.T) {
+	makeFile("secret", t)
+	addAndHide(t, "secret")
+
+	err := commands.Relayout([]string{"mirror"}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = commands.Relayout([]string{"mirror"}, func() {})
+	if err == nil {
+		t.Fatal("relayout to the current layout should fail")
+	}
+	err = commands.Relayout([]string{"sibling"}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat("secret.private"); err != nil {
+		t.Fatal("private file not moved back next to original file")
+	}
+	if _, err := os.Stat(filepath.Join(".gitprivate", "store")); err == nil {
+		t.Fatal("store directory left behind")
+	}
+
+	plain, err := os.ReadFile("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Remove("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = commands.Reveal([]string{"-keyfile", oneKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revealed, err := os.ReadFile("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plain, revealed) {
+		t.Fatal("revealed file differs")
+	}
+}
+
+func testMoveInMirrorLayout(t *testing.T) {
+	err := commands.Relayout([]string{"mirror"}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Mkdir("dir", 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+	makeFile(filepath.Join("dir", "secret"), t)
+	addAndHide(t, filepath.Join("dir", "secret"))
+
+	err = commands.Move([]string{"dir", "moved"}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(".gitprivate", "store", "moved", "secret.age")); err != nil {
+		t.Fatal("private file not moved with directory")
+	}
+	if _, err := os.Stat(filepath.Join(".gitprivate", "store", "dir", "secret.age")); err == nil {
+		t.Fatal("private file left at old path")
+	}
+}
+
+func testRelayoutFailureKeepsLayout(t *testing.T) {
+	err := os.Mkdir("config", 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+	makeFile("first", t)
+	makeFile(filepath.Join("config", "secret"), t)
+	addAndHide(t, "first", filepath.Join("config", "secret"))
+
+	// Creating the store directory of the second file fails
+	err = os.MkdirAll(filepath.Join(".gitprivate", "store"), 0770)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Symlink("missing", filepath.Join(".gitprivate", "store", "config"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = commands.Relayout([]string{"mirror"}, func() {})
+	if err == nil {
+		t.Fatal("relayout should fail")
+	}
+
+	for _, file := range []string{"first.private", filepath.Join("config", "secret.private")} {
+		if _, err := os.Stat(file); err != nil {
+			t.Fatalf("private file not kept in place: %v", err)
+		}
+	}
+
+	err = os.Remove("first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = commands.Reveal([]string{"-keyfile", oneKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat("first"); err != nil {
+		t.Fatal("file not revealed after failed relayout")
+	}
+}
+
+func testRelayoutMovesMergeAttributes(t *testing.T) {
+	makeFile("secret", t)
+	addAndHide(t, "secret")
+
+	err := commands.MergeInstall([]string{}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = commands.Relayout([]string{"blobs", "-keyfile", oneKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attributes, err := os.ReadFile(".gitattributes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(attributes), ".gitprivate/paths.json merge=gitprivate") {
+		t.Fatalf("merge attribute of the replaced file list left behind:\n%s", attributes)
+	}
+	for _, line := range []string{".gitprivate/index.dat merge=gitprivate", ".gitprivate/blobs/**/*.age merge=gitprivate"} {
+		if !strings.Contains(string(attributes), line) {
+			t.Fatalf("missing %q in attributes:\n%s", line, attributes)
+		}
+	}
+
+	err = commands.Relayout([]string{"sibling", "-keyfile", oneKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attributes, err = os.ReadFile(".gitattributes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{".gitprivate/index.dat merge=gitprivate", ".gitprivate/blobs/**/*.age merge=gitprivate"} {
+		if strings.Contains(string(attributes), line) {
+			t.Fatalf("stale %q left in attributes:\n%s", line, attributes)
+		}
+	}
+	if !strings.Contains(string(attributes), ".gitprivate/paths.json merge=gitprivate") {
+		t.Fatalf("merge attribute not moved back to the file list:\n%s", attributes)
+	}
+}
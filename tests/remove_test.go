@@ -34,12 +34,9 @@ func testRemoveNonExistingFileSucceeds(t *testing.T) {
 func testRemoveNonRevealedFileSucceeds(t *testing.T) {
 	makeFile("mysecrets", t)
 
-	err := commands.Keys([]string{"add", "-id", "hubba", "-pubfile", onePublicKey, "-keyfile", oneKey}, func() {})
-	if err != nil {
-		t.Fatal(err)
-	}
+	addTestKey(t)
 
-	err = commands.Add([]string{"mysecrets"}, func() {})
+	err := commands.Add([]string{"mysecrets"}, func() {})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -0,0 +1,241 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+
+	"github.com/erkkah/git-private/commands"
+	"github.com/erkkah/git-private/utils"
+)
+
+func TestStructured(t *testing.T) {
+	runAll(Suite{
+		name: "structured", tests: []NamedTest{
+			{"dotenv", testStructuredDotenv},
+			{"json", testStructuredJSON},
+			{"yaml", testStructuredYAML},
+			{"unchanged values", testStructuredKeepsUnchangedValues},
+			{"unsupported", testStructuredRejectsUnsupported},
+			{"swapped values", testStructuredRejectsSwappedValues},
+			{"unbound values", testStructuredRevealsUnboundValues},
+		},
+	}, t)
+}
+
+const dotenvSample = `# Database
+export DB_HOST=db.example.com
+DB_PASSWORD="correct horse"
+EMPTY=
+MULTI="first
+second"
+`
+
+const jsonSample = `{
+  "database": {
+    "host": "db.example.com",
+    "password": "correct horse",
+    "port": 5432,
+    "replica": null
+  },
+  "tokens": ["first token", "second token"],
+  "debug": false
+}
+`
+
+const yamlSample = `# Service config
+database:
+  host: db.example.com # primary
+  password: 'correct horse'
+  port: 5432
+tokens:
+- first token
+- name: second
+  secret: "second token"
+certificate: |
+  -----BEGIN CERTIFICATE-----
+  MIIB
+  -----END CERTIFICATE-----
+debug: false
+`
+
+func hideStructured(t *testing.T, file string, contents string, format string) string {
+	err := os.WriteFile(file, []byte(contents), 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addAndHide(t, "-format", format, file)
+
+	private, err := os.ReadFile(file + ".private")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(private)
+}
+
+func checkStructured(t *testing.T, file string, contents string, private string, keys []string, secrets []string) {
+	for _, key := range keys {
+		if !strings.Contains(private, key) {
+			t.Fatalf("key %q not visible in private file:\n%s", key, private)
+		}
+	}
+	for _, secret := range secrets {
+		if strings.Contains(private, secret) {
+			t.Fatalf("value %q visible in private file:\n%s", secret, private)
+		}
+	}
+
+	err := os.Remove(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = commands.Reveal([]string{"-keyfile", oneKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revealed, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(revealed) != contents {
+		t.Fatalf("revealed file differs:\n%s", revealed)
+	}
+}
+
+func testStructuredDotenv(t *testing.T) {
+	private := hideStructured(t, ".env", dotenvSample, "dotenv")
+	checkStructured(t, ".env", dotenvSample, private,
+		[]string{"# Database", "export DB_HOST=ENC[age:", "DB_PASSWORD=ENC[age:", "EMPTY=\n", "MULTI=ENC[age:"},
+		[]string{"db.example.com", "correct horse", "second"})
+}
+
+func testStructuredJSON(t *testing.T) {
+	private := hideStructured(t, "config.json", jsonSample, "json")
+	checkStructured(t, "config.json", jsonSample, private,
+		[]string{`"password": "ENC[age:`, `"port": "ENC[age:`, `"replica": null`, `"tokens": ["ENC[age:`},
+		[]string{"db.example.com", "correct horse", "5432", "first token", "false"})
+}
+
+func testStructuredYAML(t *testing.T) {
+	private := hideStructured(t, "config.yaml", yamlSample, "yaml")
+	checkStructured(t, "config.yaml", yamlSample, private,
+		[]string{"host: ENC[age:", " # primary", "- ENC[age:", "- name: ENC[age:", "  secret: ENC[age:", "certificate: ENC[age:"},
+		[]string{"db.example.com", "correct horse", "5432", "first token", "second token", "MIIB"})
+}
+
+func testStructuredKeepsUnchangedValues(t *testing.T) {
+	private := hideStructured(t, ".env", "USER=admin\nPASSWORD=secret\n", "dotenv")
+
+	err := os.WriteFile(".env", []byte("USER=admin\nPASSWORD=changed\n"), 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = commands.Hide([]string{"-keyfile", oneKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated, err := os.ReadFile(".env.private")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := strings.Split(private, "\n")
+	after := strings.Split(string(updated), "\n")
+	if before[0] != after[0] {
+		t.Fatal("unchanged value encrypted again")
+	}
+	if before[1] == after[1] {
+		t.Fatal("changed value not encrypted again")
+	}
+}
+
+func testStructuredRejectsUnsupported(t *testing.T) {
+	for _, sample := range []struct {
+		format utils.Format
+		data   string
+	}{
+		{utils.DotenvFormat, "NOT A VARIABLE\n"},
+		{utils.JSONFormat, `{"key": `},
+		{utils.YAMLFormat, "key: first line\n  continued\n"},
+		{utils.YAMLFormat, "key: \"first line\n  continued\"\n"},
+	} {
+		_, err := sample.format.Values([]byte(sample.data))
+		if err == nil {
+			t.Fatalf("%s sample %q should not parse", sample.format, sample.data)
+		}
+	}
+
+	values, err := utils.YAMLFormat.Values([]byte("a: 1\nb:\n  c: 2\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, value := range values {
+		paths = append(paths, value.Path)
+	}
+	if strings.Join(paths, ",") != "a,b.c" {
+		t.Fatalf("unexpected value paths %v", paths)
+	}
+}
+
+func testStructuredRejectsSwappedValues(t *testing.T) {
+	private := hideStructured(t, ".env", "DB_PASSWORD=secret\nREADONLY_PASSWORD=public\n", "dotenv")
+
+	lines := strings.Split(private, "\n")
+	first := strings.TrimPrefix(lines[0], "DB_PASSWORD=")
+	second := strings.TrimPrefix(lines[1], "READONLY_PASSWORD=")
+	swapped := "DB_PASSWORD=" + second + "\nREADONLY_PASSWORD=" + first + "\n"
+	err := os.WriteFile(".env.private", []byte(swapped), 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Remove(".env")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = commands.Reveal([]string{"-keyfile", oneKey}, func() {})
+	if err == nil {
+		t.Fatal("values swapped between keys should not be revealed")
+	}
+}
+
+func testStructuredRevealsUnboundValues(t *testing.T) {
+	private := hideStructured(t, ".env", "PASSWORD=secret\n", "dotenv")
+
+	// Values sealed before binding to paths hold the value only
+	publicKey, err := os.ReadFile(onePublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipient, err := age.ParseX25519Recipient(strings.TrimSpace(string(publicKey)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var encrypted bytes.Buffer
+	writer, err := age.Encrypt(&encrypted, recipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = writer.Write([]byte("unbound"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	unbound := "OLD=ENC[age:" + base64.RawStdEncoding.EncodeToString(encrypted.Bytes()) + "]\n"
+	err = os.WriteFile(".env.private", []byte(private+unbound), 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if output := catFile(t, ".env"); output != "PASSWORD=secret\nOLD=unbound\n" {
+		t.Fatalf("unexpected contents %q", output)
+	}
+}
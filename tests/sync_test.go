@@ -0,0 +1,106 @@
+package tests
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/erkkah/git-private/commands"
+	"github.com/erkkah/git-private/utils"
+)
+
+func TestSync(t *testing.T) {
+	runAll(Suite{
+		name: "sync", tests: []NamedTest{
+			{"reveal update", testSyncRevealsUpdatedFile},
+			{"hide refuses update", testHideUpdatedFileFails},
+			{"conflict", testSyncConflictFails},
+		},
+	}, t)
+}
+
+// simulateUpdate hides a new version of the file, as pulled from a remote,
+// keeping the current revealed version.
+func simulateUpdate(t *testing.T, file string) []byte {
+	makeFile(file, t)
+	addAndHide(t, file)
+	current, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	makeFile(file, t)
+	err = commands.Hide([]string{"-keyfile", oneKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Restore the previous version, and record it as synced
+	err = os.WriteFile(file, current, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := utils.LoadFileList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hasher, err := utils.NewFileHasher(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := hasher.HashFile(utils.RepoRelativePath(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = utils.RecordSynced(utils.SecureFile{Path: utils.RepoRelativePath(file), Hash: hash, PrivateHash: "previous"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return updated
+}
+
+func testSyncRevealsUpdatedFile(t *testing.T) {
+	updated := simulateUpdate(t, "secret")
+
+	err := commands.Sync([]string{"-keyfile", oneKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	revealed, err := os.ReadFile("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(revealed, updated) {
+		t.Fatal("updated file was not revealed")
+	}
+}
+
+func testHideUpdatedFileFails(t *testing.T) {
+	simulateUpdate(t, "secret")
+
+	err := commands.Hide([]string{"-keyfile", oneKey}, func() {})
+	if err == nil {
+		t.Fatal("hiding updated file should fail")
+	}
+
+	err = commands.Hide([]string{"-keyfile", oneKey, "-force"}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testSyncConflictFails(t *testing.T) {
+	simulateUpdate(t, "secret")
+	makeFile("secret", t)
+
+	err := commands.Sync([]string{"-keyfile", oneKey}, func() {})
+	if err == nil {
+		t.Fatal("sync with conflicts should fail")
+	}
+}
@@ -0,0 +1,161 @@
+package tests
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/erkkah/git-private/commands"
+)
+
+func TestValues(t *testing.T) {
+	runAll(Suite{
+		name: "values", tests: []NamedTest{
+			{"get", testGetValueWithoutRevealing},
+			{"get whole file", testGetValueFromWholeFile},
+			{"set", testSetValueUpdatesFiles},
+			{"set modified", testSetValueRefusesModifiedFile},
+			{"set dotenv quoting", testSetValueQuotesDotenv},
+		},
+	}, t)
+}
+
+func getValue(t *testing.T, file string, path string) string {
+	output := withStdio(nil, func() error {
+		return commands.Get([]string{"-keyfile", oneKey, file, path}, func() {})
+	}, t)
+	return string(output)
+}
+
+func testGetValueWithoutRevealing(t *testing.T) {
+	hideStructured(t, "config.json", jsonSample, "json")
+	err := os.Remove("config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, expected := range map[string]string{
+		"database.password": "correct horse\n",
+		"database.port":     "5432\n",
+		"tokens[1]":         "second token\n",
+	} {
+		if value := getValue(t, "config.json", path); value != expected {
+			t.Fatalf("unexpected value %q for %q", value, path)
+		}
+	}
+
+	err = commands.Get([]string{"-keyfile", oneKey, "config.json", "database.user"}, func() {})
+	if err == nil {
+		t.Fatal("getting missing value should fail")
+	}
+	if _, err := os.Stat("config.json"); err == nil {
+		t.Fatal("file revealed by get")
+	}
+}
+
+func testGetValueFromWholeFile(t *testing.T) {
+	hideStructured(t, "config.yaml", yamlSample, "whole")
+
+	if value := getValue(t, "config.yaml", "database.password"); value != "correct horse\n" {
+		t.Fatalf("unexpected value %q", value)
+	}
+	if value := getValue(t, "config.yaml", "tokens[1].secret"); value != "second token\n" {
+		t.Fatalf("unexpected value %q", value)
+	}
+	if value := getValue(t, "config.yaml", "certificate"); !strings.HasPrefix(value, "-----BEGIN CERTIFICATE-----\nMIIB\n") {
+		t.Fatalf("unexpected value %q", value)
+	}
+}
+
+func testSetValueUpdatesFiles(t *testing.T) {
+	hideStructured(t, ".env", dotenvSample, "dotenv")
+
+	err := commands.Set([]string{"-keyfile", oneKey, ".env", "DB_PASSWORD", "new password"}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	withStdio([]byte("token\n"), func() error {
+		return commands.Set([]string{"-keyfile", oneKey, ".env", "API_TOKEN", "-"}, func() {})
+	}, t)
+
+	revealed, err := os.ReadFile(".env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(revealed), "DB_PASSWORD=\"new password\"\n") || !strings.HasSuffix(string(revealed), "API_TOKEN=token\n") {
+		t.Fatalf("revealed file not updated:\n%s", revealed)
+	}
+
+	err = commands.Status([]string{"-keyfile", oneKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Remove(".env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value := getValue(t, ".env", "DB_PASSWORD"); value != "new password\n" {
+		t.Fatalf("unexpected value %q", value)
+	}
+
+	err = commands.Set([]string{"-keyfile", oneKey, ".env", "EMPTY", "filled"}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = commands.Reveal([]string{"-keyfile", oneKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revealed, err = os.ReadFile(".env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(revealed), "\nEMPTY=filled\n") {
+		t.Fatalf("empty variable not set:\n%s", revealed)
+	}
+}
+
+func testSetValueRefusesModifiedFile(t *testing.T) {
+	hideStructured(t, ".env", dotenvSample, "dotenv")
+
+	err := os.WriteFile(".env", []byte("LOCAL=change\n"), 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = commands.Set([]string{"-keyfile", oneKey, ".env", "DB_PASSWORD", "new password"}, func() {})
+	if err == nil {
+		t.Fatal("setting value in modified file should fail")
+	}
+}
+
+func testSetValueQuotesDotenv(t *testing.T) {
+	hideStructured(t, ".env", dotenvSample, "dotenv")
+
+	for _, name := range []string{"BAD NAME", "INJECTED=x", "LINE\nBREAK", "1ST"} {
+		err := commands.Set([]string{"-keyfile", oneKey, ".env", name, "value"}, func() {})
+		if err == nil {
+			t.Fatalf("setting invalid variable name %q should fail", name)
+		}
+	}
+
+	err := commands.Set([]string{"-keyfile", oneKey, ".env", "PRICE", "$5 or ${HOME}"}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revealed, err := os.ReadFile(".env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(revealed), "\nPRICE='$5 or ${HOME}'\n") {
+		t.Fatalf("value not single quoted:\n%s", revealed)
+	}
+	if value := getValue(t, ".env", "PRICE"); value != "$5 or ${HOME}\n" {
+		t.Fatalf("unexpected value %q", value)
+	}
+
+	err = commands.Set([]string{"-keyfile", oneKey, ".env", "PRICE", "it's $5"}, func() {})
+	if err == nil {
+		t.Fatal("value that can not be quoted safely should be refused")
+	}
+}
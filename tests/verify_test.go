@@ -0,0 +1,188 @@
+package tests
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/erkkah/git-private/commands"
+)
+
+func TestVerifyPush(t *testing.T) {
+	runAll(Suite{
+		name: "verify-push", tests: []NamedTest{
+			{"hidden files pass", testVerifyPushHiddenFilesPass},
+			{"plain text fails", testVerifyPushPlainTextFails},
+			{"invalid private file fails", testVerifyPushInvalidPrivateFileFails},
+			{"merge commit fails", testVerifyPushMergeCommitFails},
+			{"partly sealed blob fails", testVerifyPushPartlySealedBlobFails},
+			{"missing blob fails", testVerifyPushMissingBlobFails},
+		},
+	}, t)
+}
+
+const nullRevision = "0000000000000000000000000000000000000000"
+
+func setupHiddenCommit(t *testing.T) {
+	makeFile("secret", t)
+	addAndHide(t, "secret")
+
+	runGit(t, "add", "-A")
+	runGit(t, "commit", "-m", "hidden")
+}
+
+func testVerifyPushHiddenFilesPass(t *testing.T) {
+	setupHiddenCommit(t)
+	head := runGit(t, "rev-parse", "HEAD")
+
+	err := commands.VerifyPush([]string{nullRevision, head, "refs/heads/main"}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testVerifyPushPlainTextFails(t *testing.T) {
+	setupHiddenCommit(t)
+	base := runGit(t, "rev-parse", "HEAD")
+
+	runGit(t, "add", "-f", "secret")
+	runGit(t, "commit", "-m", "oops")
+	head := runGit(t, "rev-parse", "HEAD")
+
+	err := commands.VerifyPush([]string{base, head, "refs/heads/main"}, func() {})
+	if err == nil {
+		t.Fatal("plain text commit should be rejected")
+	}
+}
+
+func testVerifyPushInvalidPrivateFileFails(t *testing.T) {
+	setupHiddenCommit(t)
+	base := runGit(t, "rev-parse", "HEAD")
+
+	makeFile("fake.private", t)
+	runGit(t, "add", "fake.private")
+	runGit(t, "commit", "-m", "fake")
+	head := runGit(t, "rev-parse", "HEAD")
+
+	err := commands.VerifyPush([]string{base, head, "refs/heads/main"}, func() {})
+	if err == nil {
+		t.Fatal("invalid private file should be rejected")
+	}
+}
+
+func testVerifyPushMergeCommitFails(t *testing.T) {
+	setupHiddenCommit(t)
+	base := runGit(t, "rev-parse", "HEAD")
+
+	runGit(t, "checkout", "-q", "-b", "side")
+	makeFile("side.txt", t)
+	runGit(t, "add", "side.txt")
+	runGit(t, "commit", "-m", "side")
+	runGit(t, "checkout", "-q", "-")
+	makeFile("main.txt", t)
+	runGit(t, "add", "main.txt")
+	runGit(t, "commit", "-m", "main")
+
+	// The invalid private file is only introduced by the merge commit
+	runGit(t, "merge", "-q", "--no-commit", "side")
+	makeFile("fake.private", t)
+	runGit(t, "add", "fake.private")
+	runGit(t, "commit", "-m", "merge")
+	head := runGit(t, "rev-parse", "HEAD")
+
+	err := commands.VerifyPush([]string{base, head, "refs/heads/main"}, func() {})
+	if err == nil {
+		t.Fatal("invalid private file in merge commit should be rejected")
+	}
+}
+
+func testVerifyPushPartlySealedBlobFails(t *testing.T) {
+	setupBlobLayout(t)
+	runGit(t, "add", "-A")
+	runGit(t, "commit", "-m", "base")
+	base := runGit(t, "rev-parse", "HEAD")
+
+	err := os.WriteFile("config.env", []byte("USER=admin\nPASSWORD=hunter2\n"), 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = commands.Add([]string{"-format", "dotenv", "config.env"}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = commands.Hide([]string{"-keyfile", oneKey}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, "add", "-A")
+	runGit(t, "commit", "-m", "sealed")
+	head := runGit(t, "rev-parse", "HEAD")
+
+	err = commands.VerifyPush([]string{base, head, "refs/heads/main"}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blobs, err := filepath.Glob(".gitprivate/blobs/*.age")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, blob := range blobs {
+		contents, err := os.ReadFile(blob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := bytes.Split(contents, []byte("\n"))
+		if !bytes.HasPrefix(lines[0], []byte("USER=")) {
+			continue
+		}
+		lines[1] = []byte("PASSWORD=hunter2")
+		err = os.WriteFile(blob, bytes.Join(lines, []byte("\n")), 0660)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	runGit(t, "commit", "-a", "-m", "partly sealed")
+	head = runGit(t, "rev-parse", "HEAD")
+
+	err = commands.VerifyPush([]string{base, head, "refs/heads/main"}, func() {})
+	if err == nil {
+		t.Fatal("partly sealed private file should be rejected")
+	}
+}
+
+func testVerifyPushMissingBlobFails(t *testing.T) {
+	setupBlobLayout(t)
+	runGit(t, "add", "-A")
+	runGit(t, "commit", "-m", "base")
+	base := runGit(t, "rev-parse", "HEAD")
+
+	blobs, err := filepath.Glob(".gitprivate/blobs/*.age")
+	if err != nil || len(blobs) != 1 {
+		t.Fatalf("expected one blob, found %v: %v", blobs, err)
+	}
+	runGit(t, "rm", "-q", blobs[0])
+	runGit(t, "commit", "-m", "missing blob")
+	head := runGit(t, "rev-parse", "HEAD")
+
+	err = commands.VerifyPush([]string{base, head, "refs/heads/main"}, func() {})
+	if err == nil {
+		t.Fatal("hidden file without private file should be rejected")
+	}
+
+	// Removing the file from the index too is fine
+	runGit(t, "reset", "-q", "--hard", base)
+	err = commands.Remove([]string{"secret"}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, "add", "-A")
+	runGit(t, "commit", "-m", "removed")
+	head = runGit(t, "rev-parse", "HEAD")
+
+	err = commands.VerifyPush([]string{base, head, "refs/heads/main"}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -32,6 +32,16 @@ func StateDir() (AbsolutePath, error) {
 	return path, nil
 }
 
+// RepoStateDir returns the state directory, relative to the repo root.
+// Used where there is no working tree, as in bare repos.
+func RepoStateDir() (RepoRelativePath, error) {
+	dir := privateDir()
+	if filepath.IsAbs(dir) {
+		return "", fmt.Errorf("state directory %q is not in the repo", dir)
+	}
+	return RepoRelativePath(filepath.ToSlash(filepath.Clean(dir))), nil
+}
+
 func KeysFile() (AbsolutePath, error) {
 	dir, err := StateDir()
 	if err != nil {
@@ -48,13 +58,70 @@ func PathsFile() (AbsolutePath, error) {
 	return dir.Join("paths.json"), nil
 }
 
-func EnsureInitialized() error {
+func ScanRulesFile() (AbsolutePath, error) {
 	dir, err := StateDir()
 	if err != nil {
-		return err
+		return "", err
+	}
+	return dir.Join("scan.json"), nil
+}
+
+func SettingsFile() (AbsolutePath, error) {
+	dir, err := StateDir()
+	if err != nil {
+		return "", err
+	}
+	return dir.Join("settings.json"), nil
+}
+
+func DataKeyFile() (AbsolutePath, error) {
+	dir, err := StateDir()
+	if err != nil {
+		return "", err
+	}
+	return dir.Join("datakey.age"), nil
+}
+
+func HashKeyFile() (AbsolutePath, error) {
+	dir, err := StateDir()
+	if err != nil {
+		return "", err
+	}
+	return dir.Join("hashkey.age"), nil
+}
+
+// IndexFile returns the path to the encrypted file list used in the blob layout.
+func IndexFile() (AbsolutePath, error) {
+	dir, err := StateDir()
+	if err != nil {
+		return "", err
 	}
-	exists, err := Exists(dir)
-	if exists {
+	return dir.Join("index.dat"), nil
+}
+
+// HashKeyCacheFile returns the path to the local copy of the hash key,
+// which makes it possible to check file status without a private key.
+func HashKeyCacheFile() (AbsolutePath, error) {
+	gitDir, err := GitDir()
+	if err != nil {
+		return "", err
+	}
+	return gitDir.Join(RepoRelativePath(filepath.Join(ToolName, "hashkey.json"))), nil
+}
+
+// SyncStateFile returns the path to the local record of synced files.
+// It is kept in the git directory, since it describes the work tree.
+func SyncStateFile() (AbsolutePath, error) {
+	gitDir, err := GitDir()
+	if err != nil {
+		return "", err
+	}
+	return gitDir.Join(RepoRelativePath(filepath.Join(ToolName, "synced.json"))), nil
+}
+
+func EnsureInitialized() error {
+	initialized, err := IsInitialized()
+	if initialized {
 		return nil
 	}
 	if err != nil {
@@ -62,3 +129,12 @@ func EnsureInitialized() error {
 	}
 	return fmt.Errorf("not initialized, run '%s init'", ToolName)
 }
+
+// IsInitialized checks if the state directory exists.
+func IsInitialized() (bool, error) {
+	dir, err := StateDir()
+	if err != nil {
+		return false, err
+	}
+	return Exists(dir)
+}
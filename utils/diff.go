@@ -0,0 +1,85 @@
+package utils
+
+// DiffLine is a line of a line based diff, referring to lines of the
+// old and new versions by index.
+type DiffLine struct {
+	// Kind is ' ' for unchanged, '-' for removed and '+' for added lines
+	Kind byte
+	// From is the index in the old version, -1 for added lines
+	From int
+	// To is the index in the new version, -1 for removed lines
+	To int
+}
+
+// Above this many line pairs, changed parts are diffed as a whole
+const maxDiffSize = 1 << 22
+
+// DiffLines returns a minimal line diff between two versions.
+func DiffLines(from []string, to []string) []DiffLine {
+	var diff []DiffLine
+
+	prefix := 0
+	for prefix < len(from) && prefix < len(to) && from[prefix] == to[prefix] {
+		diff = append(diff, DiffLine{' ', prefix, prefix})
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(from)-prefix && suffix < len(to)-prefix &&
+		from[len(from)-1-suffix] == to[len(to)-1-suffix] {
+		suffix++
+	}
+
+	a := from[prefix : len(from)-suffix]
+	b := to[prefix : len(to)-suffix]
+
+	if len(a)*len(b) > maxDiffSize {
+		for i := range a {
+			diff = append(diff, DiffLine{'-', prefix + i, -1})
+		}
+		for j := range b {
+			diff = append(diff, DiffLine{'+', -1, prefix + j})
+		}
+	} else {
+		// Longest common subsequence lengths of a[i:] and b[j:]
+		width := len(b) + 1
+		common := make([]int32, (len(a)+1)*width)
+		for i := len(a) - 1; i >= 0; i-- {
+			for j := len(b) - 1; j >= 0; j-- {
+				if a[i] == b[j] {
+					common[i*width+j] = common[(i+1)*width+j+1] + 1
+				} else {
+					common[i*width+j] = max32(common[(i+1)*width+j], common[i*width+j+1])
+				}
+			}
+		}
+
+		i, j := 0, 0
+		for i < len(a) || j < len(b) {
+			switch {
+			case i < len(a) && j < len(b) && a[i] == b[j]:
+				diff = append(diff, DiffLine{' ', prefix + i, prefix + j})
+				i++
+				j++
+			case j == len(b) || (i < len(a) && common[(i+1)*width+j] >= common[i*width+j+1]):
+				diff = append(diff, DiffLine{'-', prefix + i, -1})
+				i++
+			default:
+				diff = append(diff, DiffLine{'+', -1, prefix + j})
+				j++
+			}
+		}
+	}
+
+	for k := suffix; k > 0; k-- {
+		diff = append(diff, DiffLine{' ', len(from) - k, len(to) - k})
+	}
+
+	return diff
+}
+
+func max32(a int32, b int32) int32 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -6,6 +6,8 @@ import (
 	"errors"
 	"io"
 	"os"
+	"path/filepath"
+	"regexp"
 	"strings"
 )
 
@@ -41,6 +43,98 @@ func GetFileHash(path RepoRelativePath) (string, error) {
 	return string(encoded), nil
 }
 
+// GetDataHash hashes data like GetFileHash hashes file contents.
+func GetDataHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// MatchPattern matches a repo relative path against a gitignore style pattern.
+// Patterns without a slash match the file name at any depth, "**" matches
+// any number of directories and "*", "?" and bracket expressions like
+// "[0-9]" or "[!a-z]" do not match slashes.
+func MatchPattern(pattern string, file RepoRelativePath) bool {
+	pattern = strings.TrimPrefix(pattern, "/")
+	if !strings.Contains(pattern, "/") {
+		pattern = "**/" + pattern
+	}
+
+	var expression strings.Builder
+	expression.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			expression.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			expression.WriteString(".*")
+			i++
+		case c == '*':
+			expression.WriteString("[^/]*")
+		case c == '?':
+			expression.WriteString("[^/]")
+		case c == '[':
+			class, length := bracketExpression(pattern[i:])
+			if length == 0 {
+				expression.WriteString(regexp.QuoteMeta(string(c)))
+				continue
+			}
+			expression.WriteString(class)
+			i += length - 1
+		default:
+			expression.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expression.WriteString("$")
+
+	matched, err := regexp.MatchString(expression.String(), filepath.ToSlash(file.Relative()))
+	return err == nil && matched
+}
+
+// bracketExpression translates a glob bracket expression at the start of
+// the pattern to a regexp character class, returning the class and the
+// length of the expression. A zero length means that the bracket is not
+// closed, and should be matched literally.
+func bracketExpression(pattern string) (string, int) {
+	i := 1
+	negated := false
+	if i < len(pattern) && (pattern[i] == '!' || pattern[i] == '^') {
+		negated = true
+		i++
+	}
+
+	var class strings.Builder
+	for first := true; i < len(pattern); first = false {
+		c := pattern[i]
+		switch {
+		case c == ']' && !first:
+			if negated {
+				return "[^/" + class.String() + "]", i + 1
+			}
+			return "[" + class.String() + "]", i + 1
+		case c == '[' && strings.HasPrefix(pattern[i:], "[:"):
+			end := strings.Index(pattern[i+2:], ":]")
+			if end == -1 {
+				class.WriteString(`\[`)
+				i++
+				continue
+			}
+			class.WriteString(pattern[i : i+2+end+2])
+			i += 2 + end + 2
+		case c == '\\' && i+1 < len(pattern):
+			class.WriteString(regexp.QuoteMeta(string(pattern[i+1])))
+			i += 2
+		case c == '/':
+			// Slashes are never matched
+			i++
+		default:
+			class.WriteString(regexp.QuoteMeta(string(c)))
+			i++
+		}
+	}
+	return "", 0
+}
+
 func ReadFromFileOrStdin(file string) (string, error) {
 	var data []byte
 	var err error
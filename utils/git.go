@@ -1,6 +1,8 @@
 package utils
 
 import (
+	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path"
@@ -42,23 +44,41 @@ func GetGitRootPath() (AbsolutePath, error) {
 	return AbsolutePath(root), nil
 }
 
-func getIgnoreFilePath() (AbsolutePath, error) {
+// GitDir returns the git directory of the current work tree.
+func GitDir() (AbsolutePath, error) {
+	gitDir, code, err := runGitCommand("rev-parse", "--git-dir")
+	if code != 0 {
+		if err == nil {
+			err = fmt.Errorf("failed to locate git directory")
+		}
+		return "", err
+	}
+	gitDir, err = filepath.Abs(strings.TrimSpace(gitDir))
+	if err != nil {
+		return "", err
+	}
+	return AbsolutePath(gitDir), nil
+}
+
+func getRootFilePath(name RepoRelativePath) (AbsolutePath, error) {
 	root, err := GetGitRootPath()
 	if err != nil {
 		return "", err
 	}
 
-	ignoreFile := root.Join(".gitignore")
-	return ignoreFile, nil
+	return root.Join(name), nil
 }
 
-func readIgnoreFile() ([]string, error) {
-	ignoreFile, err := getIgnoreFilePath()
+func readRootFile(name RepoRelativePath) ([]string, error) {
+	rootFile, err := getRootFilePath(name)
 	if err != nil {
 		return nil, err
 	}
+	return readLines(rootFile)
+}
 
-	exists, err := Exists(ignoreFile)
+func readLines(file AbsolutePath) ([]string, error) {
+	exists, err := Exists(file)
 	if err != nil {
 		return []string{}, err
 	}
@@ -66,7 +86,7 @@ func readIgnoreFile() ([]string, error) {
 		return []string{}, nil
 	}
 
-	contents, err := os.ReadFile(ignoreFile.Absolute())
+	contents, err := os.ReadFile(file.Absolute())
 	if err != nil {
 		return nil, err
 	}
@@ -75,20 +95,106 @@ func readIgnoreFile() ([]string, error) {
 	return lines, nil
 }
 
-func writeIgnoreFile(lines []string) error {
-	ignoreFile, err := getIgnoreFilePath()
+func writeRootFile(name RepoRelativePath, lines []string) error {
+	rootFile, err := getRootFilePath(name)
 	if err != nil {
 		return err
 	}
-	err = os.WriteFile(ignoreFile.Absolute(), []byte(strings.Join(lines, "\n")), 0660)
+	return writeLines(rootFile, lines)
+}
+
+func writeLines(file AbsolutePath, lines []string) error {
+	err := os.WriteFile(file.Absolute(), []byte(strings.Join(lines, "\n")), 0660)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+func readIgnoreFile() ([]string, error) {
+	return readRootFile(".gitignore")
+}
+
+func writeIgnoreFile(lines []string) error {
+	return writeRootFile(".gitignore", lines)
+}
+
+func localExcludeFile() (AbsolutePath, error) {
+	gitDir, err := GitDir()
+	if err != nil {
+		return "", err
+	}
+	return gitDir.Join(RepoRelativePath(filepath.Join("info", "exclude"))), nil
+}
+
+func readLocalExcludeFile() ([]string, error) {
+	file, err := localExcludeFile()
+	if err != nil {
+		return nil, err
+	}
+	return readLines(file)
+}
+
+func writeLocalExcludeFile(lines []string) error {
+	file, err := localExcludeFile()
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(file.Absolute()), 0770)
+	if err != nil {
+		return err
+	}
+	return writeLines(file, lines)
+}
+
 func GitRemoveIgnorePattern(pattern string) error {
-	lines, err := readIgnoreFile()
+	return removeIgnorePattern(pattern, readIgnoreFile, writeIgnoreFile)
+}
+
+func GitAddIgnorePattern(pattern string) error {
+	return addIgnorePattern(pattern, readIgnoreFile, writeIgnoreFile)
+}
+
+// GitRemoveLocalIgnorePattern removes a pattern from the local exclude file.
+func GitRemoveLocalIgnorePattern(pattern string) error {
+	return removeIgnorePattern(pattern, readLocalExcludeFile, writeLocalExcludeFile)
+}
+
+// GitAddLocalIgnorePattern adds a pattern to the local exclude file,
+// which ignores files in this clone only.
+func GitAddLocalIgnorePattern(pattern string) error {
+	return addIgnorePattern(pattern, readLocalExcludeFile, writeLocalExcludeFile)
+}
+
+// GitAddLocalIgnorePatterns adds all missing patterns to the local
+// exclude file at once.
+func GitAddLocalIgnorePatterns(patterns []string) error {
+	lines, err := readLocalExcludeFile()
+	if err != nil {
+		return err
+	}
+
+	existing := map[string]bool{}
+	for _, line := range lines {
+		existing[strings.TrimSpace(line)] = true
+	}
+
+	added := false
+	for _, pattern := range patterns {
+		if !existing[pattern] {
+			existing[pattern] = true
+			lines = append(lines, pattern)
+			added = true
+		}
+	}
+	if !added {
+		return nil
+	}
+	return writeLocalExcludeFile(lines)
+}
+
+func removeIgnorePattern(pattern string, read func() ([]string, error), write func([]string) error) error {
+	lines, err := read()
 	if err != nil {
 		return err
 	}
@@ -100,15 +206,15 @@ func GitRemoveIgnorePattern(pattern string) error {
 		}
 	}
 
-	err = writeIgnoreFile(updatedLines)
+	err = write(updatedLines)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func GitAddIgnorePattern(pattern string) error {
-	lines, err := readIgnoreFile()
+func addIgnorePattern(pattern string, read func() ([]string, error), write func([]string) error) error {
+	lines, err := read()
 	if err != nil {
 		return err
 	}
@@ -121,7 +227,32 @@ func GitAddIgnorePattern(pattern string) error {
 	}
 
 	lines = append(lines, pattern)
-	err = writeIgnoreFile(lines)
+	err = write(lines)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GitAddAttributes adds a line assigning the given attributes to
+// the given pattern in the top level .gitattributes file.
+func GitAddAttributes(pattern string, attributes string) error {
+	lines, err := readRootFile(".gitattributes")
+	if err != nil {
+		return err
+	}
+
+	attributeLine := pattern + " " + attributes
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == attributeLine {
+			return nil
+		}
+	}
+
+	lines = append(lines, attributeLine)
+	err = writeRootFile(".gitattributes", lines)
 	if err != nil {
 		return err
 	}
@@ -129,6 +260,421 @@ func GitAddIgnorePattern(pattern string) error {
 	return nil
 }
 
+// GitRemoveAttributes removes the line assigning the given attributes to
+// the given pattern from the top level .gitattributes file.
+func GitRemoveAttributes(pattern string, attributes string) error {
+	lines, err := readRootFile(".gitattributes")
+	if err != nil {
+		return err
+	}
+
+	attributeLine := pattern + " " + attributes
+	var updatedLines []string
+	for _, line := range lines {
+		if strings.TrimSpace(line) != attributeLine {
+			updatedLines = append(updatedLines, line)
+		}
+	}
+	if len(updatedLines) == len(lines) {
+		return nil
+	}
+
+	return writeRootFile(".gitattributes", updatedLines)
+}
+
+// GitSetConfig sets a value in the local repo config.
+func GitSetConfig(key string, value string) error {
+	_, code, err := runGitCommand("config", "--local", key, value)
+	if code != 0 {
+		if err == nil {
+			err = fmt.Errorf("failed to set git config %q", key)
+		}
+		return err
+	}
+	return nil
+}
+
+// GitGetConfig gets a value from the git config.
+// Returns false if the value is not set.
+func GitGetConfig(key string) (string, bool, error) {
+	value, code, err := runGitCommand("config", "--get", key)
+	if code != 0 {
+		return "", false, err
+	}
+	return strings.TrimSpace(value), true, nil
+}
+
+// GitHooksDir returns the directory where git looks for hooks,
+// taking "core.hooksPath" into account.
+func GitHooksDir() (AbsolutePath, error) {
+	hooksPath, isSet, err := GitGetConfig("core.hooksPath")
+	if err != nil {
+		return "", err
+	}
+	if isSet {
+		if filepath.IsAbs(hooksPath) {
+			return AbsolutePath(hooksPath), nil
+		}
+		return RepoAbsolute(RepoRelativePath(hooksPath))
+	}
+
+	hooksPath, code, err := runGitCommand("rev-parse", "--git-path", "hooks")
+	if code != 0 {
+		if err == nil {
+			err = fmt.Errorf("failed to locate git hooks directory")
+		}
+		return "", err
+	}
+	hooksPath, err = filepath.Abs(strings.TrimSpace(hooksPath))
+	if err != nil {
+		return "", err
+	}
+	return AbsolutePath(hooksPath), nil
+}
+
+// GitChangedFiles lists repo relative paths of files that differ between two revisions.
+func GitChangedFiles(from string, to string) ([]RepoRelativePath, error) {
+	output, code, err := runGitCommand("diff", "--name-only", "-z", from, to, "--")
+	if code != 0 {
+		if err == nil {
+			err = fmt.Errorf("failed to list changes between %q and %q", from, to)
+		}
+		return nil, err
+	}
+	return splitNullTerminated(output), nil
+}
+
+// GitRevList lists commits using 'git rev-list' with the given arguments.
+func GitRevList(args ...string) ([]string, error) {
+	output, code, err := runGitCommand(append([]string{"rev-list"}, args...)...)
+	if code != 0 {
+		if err == nil {
+			err = fmt.Errorf("failed to list revisions")
+		}
+		return nil, err
+	}
+	return strings.Fields(output), nil
+}
+
+// GitListTree lists all files in the tree of the given revision.
+func GitListTree(revision string) ([]RepoRelativePath, error) {
+	output, code, err := runGitCommand("ls-tree", "-r", "-z", "--name-only", revision)
+	if code != 0 {
+		if err == nil {
+			err = fmt.Errorf("failed to list tree of %q", revision)
+		}
+		return nil, err
+	}
+	return splitNullTerminated(output), nil
+}
+
+// GitCommitChanges lists files added or modified by the given commit.
+// Merge commits are compared to each of their parents.
+func GitCommitChanges(commit string) ([]RepoRelativePath, error) {
+	output, code, err := runGitCommand("diff-tree", "-r", "-z", "-m", "--root", "--no-commit-id",
+		"--name-only", "--diff-filter=AM", commit)
+	if code != 0 {
+		if err == nil {
+			err = fmt.Errorf("failed to list changes in %q", commit)
+		}
+		return nil, err
+	}
+
+	var changes []RepoRelativePath
+	seen := map[RepoRelativePath]bool{}
+	for _, file := range splitNullTerminated(output) {
+		if !seen[file] {
+			seen[file] = true
+			changes = append(changes, file)
+		}
+	}
+	return changes, nil
+}
+
+func splitNullTerminated(output string) []RepoRelativePath {
+	var files []RepoRelativePath
+	for _, file := range strings.Split(output, "\x00") {
+		if file != "" {
+			files = append(files, RepoRelativePath(file))
+		}
+	}
+	return files
+}
+
+// GitFileChange is a change to a file in a commit.
+type GitFileChange struct {
+	Commit string
+	Author string
+	Date   string
+	Path   RepoRelativePath
+}
+
+// GitFileHistory lists changes, in commits reachable from any ref,
+// that add or modify files matching the given pathspecs. Merge commits
+// are included for files that differ from all parents.
+func GitFileHistory(pathspecs []string) ([]GitFileChange, error) {
+	const commitMarker = "\x01"
+
+	args := []string{"-c", "core.quotepath=off", "log", "--all", "-c", "--diff-filter=AM", "--name-only",
+		"--format=" + commitMarker + "%H%x00%an <%ae>%x00%aI", "--"}
+	args = append(args, pathspecs...)
+
+	output, code, err := runGitCommand(args...)
+	if code != 0 {
+		if err == nil {
+			err = fmt.Errorf("failed to read history")
+		}
+		return nil, err
+	}
+
+	var changes []GitFileChange
+	var current GitFileChange
+
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, commitMarker) {
+			fields := strings.Split(strings.TrimPrefix(line, commitMarker), "\x00")
+			if len(fields) != 3 {
+				return nil, fmt.Errorf("unexpected log output %q", line)
+			}
+			current = GitFileChange{
+				Commit: fields[0],
+				Author: fields[1],
+				Date:   fields[2],
+			}
+			continue
+		}
+		if line == "" || current.Commit == "" {
+			continue
+		}
+		change := current
+		change.Path = RepoRelativePath(line)
+		changes = append(changes, change)
+	}
+
+	return changes, nil
+}
+
+// GitFileLog lists commits reachable from HEAD that change the given file,
+// newest first, following renames. The path of the file in each commit
+// is returned with the commit.
+func GitFileLog(file RepoRelativePath) ([]GitFileChange, error) {
+	const commitMarker = "\x01"
+
+	output, code, err := runGitCommand("-c", "core.quotepath=off", "log", "--follow", "--name-only",
+		"--format="+commitMarker+"%H%x00%an <%ae>%x00%aI", "--", ":(top,literal)"+filepath.ToSlash(file.Relative()))
+	if code != 0 {
+		if err == nil {
+			err = fmt.Errorf("failed to read history of %q", file)
+		}
+		return nil, err
+	}
+
+	var changes []GitFileChange
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, commitMarker) {
+			fields := strings.Split(strings.TrimPrefix(line, commitMarker), "\x00")
+			if len(fields) != 3 {
+				return nil, fmt.Errorf("unexpected log output %q", line)
+			}
+			changes = append(changes, GitFileChange{
+				Commit: fields[0],
+				Author: fields[1],
+				Date:   fields[2],
+			})
+			continue
+		}
+		if line == "" || len(changes) == 0 {
+			continue
+		}
+		changes[len(changes)-1].Path = RepoRelativePath(filepath.FromSlash(line))
+	}
+
+	return changes, nil
+}
+
+// GitObjectID resolves the object ID of the given object name, for example "commit:path".
+func GitObjectID(object string) (string, error) {
+	id, code, err := runGitCommand("rev-parse", "--verify", "--quiet", object)
+	if code != 0 {
+		if err == nil {
+			err = fmt.Errorf("no such object %q", object)
+		}
+		return "", err
+	}
+	return strings.TrimSpace(id), nil
+}
+
+// GitIsTracked checks if the given file is in the git index.
+func GitIsTracked(file RepoRelativePath) (bool, error) {
+	root, err := GetGitRootPath()
+	if err != nil {
+		return false, err
+	}
+	_, code, err := runGitCommand("-C", root.Absolute(), "ls-files", "--error-unmatch", "--", file.Relative())
+	if code == 0 {
+		return true, nil
+	}
+	return false, err
+}
+
+// GitUntrack removes the given file from the git index, keeping it in the working tree.
+func GitUntrack(file RepoRelativePath) error {
+	root, err := GetGitRootPath()
+	if err != nil {
+		return err
+	}
+	_, code, err := runGitCommand("-C", root.Absolute(), "rm", "--cached", "--quiet", "--", file.Relative())
+	if code != 0 {
+		if err == nil {
+			err = fmt.Errorf("failed to remove %q from the index", file)
+		}
+		return err
+	}
+	return nil
+}
+
+// GitMove moves a file or directory containing tracked files, updating the index.
+func GitMove(from RepoRelativePath, to RepoRelativePath) error {
+	root, err := GetGitRootPath()
+	if err != nil {
+		return err
+	}
+	_, code, err := runGitCommand("-C", root.Absolute(), "mv", "--", from.Relative(), to.Relative())
+	if code != 0 {
+		if err == nil {
+			err = fmt.Errorf("failed to move %q to %q", from, to)
+		}
+		return err
+	}
+	return nil
+}
+
+// GitListFiles lists tracked and untracked, not ignored, files in the working tree.
+func GitListFiles() ([]RepoRelativePath, error) {
+	root, err := GetGitRootPath()
+	if err != nil {
+		return nil, err
+	}
+	output, code, err := runGitCommand("-C", root.Absolute(), "ls-files", "-z", "--cached", "--others", "--exclude-standard")
+	if code != 0 {
+		if err == nil {
+			err = fmt.Errorf("failed to list files")
+		}
+		return nil, err
+	}
+	return splitNullTerminated(output), nil
+}
+
+// GitListTrackedFiles lists files in the git index.
+func GitListTrackedFiles() ([]RepoRelativePath, error) {
+	root, err := GetGitRootPath()
+	if err != nil {
+		return nil, err
+	}
+	output, code, err := runGitCommand("-C", root.Absolute(), "ls-files", "-z", "--cached")
+	if code != 0 {
+		if err == nil {
+			err = fmt.Errorf("failed to list files")
+		}
+		return nil, err
+	}
+	return splitNullTerminated(output), nil
+}
+
+// GitListAllFiles lists tracked and untracked files in the working tree,
+// including ignored files.
+func GitListAllFiles() ([]RepoRelativePath, error) {
+	root, err := GetGitRootPath()
+	if err != nil {
+		return nil, err
+	}
+	output, code, err := runGitCommand("-C", root.Absolute(), "ls-files", "-z", "--cached", "--others")
+	if code != 0 {
+		if err == nil {
+			err = fmt.Errorf("failed to list files")
+		}
+		return nil, err
+	}
+	return splitNullTerminated(output), nil
+}
+
+// GitAdd adds the given files to the index.
+func GitAdd(files ...RepoRelativePath) error {
+	root, err := GetGitRootPath()
+	if err != nil {
+		return err
+	}
+	args := []string{"-C", root.Absolute(), "add", "--"}
+	for _, file := range files {
+		args = append(args, file.Relative())
+	}
+	_, code, err := runGitCommand(args...)
+	if code != 0 {
+		if err == nil {
+			err = fmt.Errorf("failed to add files to index")
+		}
+		return err
+	}
+	return nil
+}
+
+// GitReadBlob reads the contents of a git object, given in any form
+// accepted by 'git cat-file', for example ":path" for the index version of a file.
+// Returns false if there is no such object.
+func GitReadBlob(object string) ([]byte, bool, error) {
+	contents, code, err := runGitCommand("cat-file", "blob", object)
+	if code != 0 {
+		return nil, false, err
+	}
+	return []byte(contents), true, nil
+}
+
+// GitOpenBlob opens the contents of a git object for reading, like
+// GitReadBlob, streamed from git. Returns false if there is no such object.
+func GitOpenBlob(object string) (io.ReadCloser, bool, error) {
+	_, code, err := runGitCommand("cat-file", "-e", object)
+	if code != 0 {
+		return nil, false, err
+	}
+
+	cmd := exec.Command("git", "cat-file", "blob", object)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, false, err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, false, err
+	}
+	return &blobReader{ReadCloser: stdout, cmd: cmd}, true, nil
+}
+
+type blobReader struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+// Close stops reading, and waits for git to exit.
+func (reader *blobReader) Close() error {
+	reader.ReadCloser.Close()
+	return reader.cmd.Wait()
+}
+
+// GitMergeFile runs a three-way merge of the given files and returns the result,
+// which has conflict markers if there were conflicts, and the number of conflicts.
+func GitMergeFile(ours AbsolutePath, base AbsolutePath, theirs AbsolutePath) ([]byte, int, error) {
+	merged, code, err := runGitCommand("merge-file", "-p",
+		"-L", "ours", "-L", "base", "-L", "theirs",
+		ours.Absolute(), base.Absolute(), theirs.Absolute())
+	if err != nil {
+		return nil, 0, err
+	}
+	if code < 0 || code > 127 {
+		return nil, 0, fmt.Errorf("merge failed")
+	}
+	return []byte(merged), code, nil
+}
+
 func IsGitIgnored(fileName string) (bool, error) {
 	_, code, err := runGitCommand("check-ignore", "-q", fileName)
 	if code == 0 {
@@ -0,0 +1,214 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+
+	"filippo.io/age"
+)
+
+// KeyedHashVersion is the first file list version where plain text
+// hashes are keyed by the repo hash key.
+const KeyedHashVersion = 2
+
+const hashKeySize = 32
+
+// ErrNoHashKey is returned when the hash key is needed, but not cached locally.
+var ErrNoHashKey = errors.New("hash key not available")
+
+// FileHasher hashes plain text contents of private files.
+// Version 1 file lists use plain SHA-256 hashes. Later versions use
+// HMAC-SHA-256, keyed by the repo hash key, so that short secrets
+// can not be brute-forced from their hashes.
+type FileHasher struct {
+	key []byte
+}
+
+// NewFileHasher creates a hasher for the given file list,
+// using the locally cached hash key if needed.
+func NewFileHasher(list FileList) (FileHasher, error) {
+	if list.Version < KeyedHashVersion {
+		return FileHasher{}, nil
+	}
+
+	key, found, err := LoadCachedHashKey()
+	if err != nil {
+		return FileHasher{}, err
+	}
+	if !found {
+		return FileHasher{}, ErrNoHashKey
+	}
+	return FileHasher{key: key}, nil
+}
+
+// NewKeyedFileHasher creates a hasher using the given hash key.
+func NewKeyedFileHasher(key []byte) FileHasher {
+	return FileHasher{key: key}
+}
+
+func (hasher FileHasher) newHash() hash.Hash {
+	if hasher.key == nil {
+		return sha256.New()
+	}
+	return hmac.New(sha256.New, hasher.key)
+}
+
+// Hash hashes the given plain text data.
+func (hasher FileHasher) Hash(data []byte) string {
+	hash := hasher.newHash()
+	hash.Write(data)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// HashFile hashes the plain text contents of the given file.
+func (hasher FileHasher) HashFile(path RepoRelativePath) (string, error) {
+	absolute, err := RepoAbsolute(path)
+	if err != nil {
+		return "", err
+	}
+	file, err := absolute.Open()
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := hasher.newHash()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// GenerateHashKey generates a new random hash key.
+func GenerateHashKey() ([]byte, error) {
+	key := make([]byte, hashKeySize)
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// LoadHashKey loads the hash key, and updates the local copy.
+// Returns false if there is no hash key.
+func LoadHashKey(identity age.Identity) ([]byte, bool, error) {
+	file, err := HashKeyFile()
+	if err != nil {
+		return nil, false, err
+	}
+
+	key, found, err := loadWrappedSecret(file, identity)
+	if err != nil || !found {
+		return nil, false, err
+	}
+	if len(key) != hashKeySize {
+		return nil, false, fmt.Errorf("invalid hash key")
+	}
+
+	err = cacheHashKey(key)
+	if err != nil {
+		return nil, false, err
+	}
+	return key, true, nil
+}
+
+// StoreHashKey stores the hash key, encrypted to all keys in the key list,
+// and updates the local copy.
+func StoreHashKey(identity age.Identity, key []byte) error {
+	file, err := HashKeyFile()
+	if err != nil {
+		return err
+	}
+
+	err = storeWrappedSecret(file, identity, key)
+	if err != nil {
+		return err
+	}
+
+	return cacheHashKey(key)
+}
+
+type hashKeyCache struct {
+	// Hash of the encrypted hash key file the key was loaded from
+	Source string
+	Key    string
+}
+
+// LoadCachedHashKey loads the local copy of the hash key.
+// Returns false if there is no copy, or if the hash key has been
+// replaced since the copy was made.
+func LoadCachedHashKey() ([]byte, bool, error) {
+	cacheFile, err := HashKeyCacheFile()
+	if err != nil {
+		return nil, false, err
+	}
+	exists, err := Exists(cacheFile)
+	if err != nil || !exists {
+		return nil, false, err
+	}
+
+	var cache hashKeyCache
+	err = load(cacheFile, &cache)
+	if err != nil {
+		return nil, false, err
+	}
+
+	source, err := hashKeySource()
+	if err != nil {
+		return nil, false, err
+	}
+	if source != cache.Source {
+		return nil, false, nil
+	}
+
+	key, err := hex.DecodeString(cache.Key)
+	if err != nil || len(key) != hashKeySize {
+		return nil, false, nil
+	}
+	return key, true, nil
+}
+
+func cacheHashKey(key []byte) error {
+	cacheFile, err := HashKeyCacheFile()
+	if err != nil {
+		return err
+	}
+	source, err := hashKeySource()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(cacheFile.Absolute()), 0770)
+	if err != nil {
+		return err
+	}
+	return store(cacheFile, &hashKeyCache{
+		Source: source,
+		Key:    hex.EncodeToString(key),
+	})
+}
+
+func hashKeySource() (string, error) {
+	file, err := HashKeyFile()
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(file.Absolute())
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,248 @@
+package utils
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+const blobsDir = "blobs"
+const storeDir = "store"
+const blobExtension = ".age"
+const blobNameSize = 16
+
+const indexKeyInfo = "git-private file index"
+
+// PrivatePath returns the path of the private file of the given file,
+// in the layout of the repo.
+func PrivatePath(file SecureFile) (RepoRelativePath, error) {
+	settings, err := LoadSettings()
+	if err != nil {
+		return "", err
+	}
+	return settings.Layout.PrivatePath(file)
+}
+
+// PrivatePath returns the path of the private file of the given file
+// in this layout. Files with blob names are always stored as blobs.
+func (layout Layout) PrivatePath(file SecureFile) (RepoRelativePath, error) {
+	if file.Blob == "" && layout != MirrorLayout {
+		return file.Path + PrivateExtension, nil
+	}
+
+	stateDir, err := RepoStateDir()
+	if err != nil {
+		return "", err
+	}
+	base := filepath.FromSlash(stateDir.Relative())
+
+	if file.Blob != "" {
+		return RepoRelativePath(filepath.Join(base, blobsDir, file.Blob+blobExtension)), nil
+	}
+	return RepoRelativePath(filepath.Join(base, storeDir, file.Path.Relative()+blobExtension)), nil
+}
+
+// StoreDir returns the directory holding the private files in the
+// given layout, or an empty path if they are stored next to the original files.
+func (layout Layout) StoreDir() (RepoRelativePath, error) {
+	var dir string
+	switch layout {
+	case BlobLayout:
+		dir = blobsDir
+	case MirrorLayout:
+		dir = storeDir
+	default:
+		return "", nil
+	}
+
+	stateDir, err := RepoStateDir()
+	if err != nil {
+		return "", err
+	}
+	return RepoRelativePath(filepath.Join(filepath.FromSlash(stateDir.Relative()), dir)), nil
+}
+
+// IsPrivateFile checks if the given path is a private file, in any layout.
+func IsPrivateFile(path RepoRelativePath) bool {
+	if strings.HasSuffix(path.Relative(), PrivateExtension) {
+		return true
+	}
+	stateDir, err := RepoStateDir()
+	if err != nil {
+		return false
+	}
+	slashed := filepath.ToSlash(path.Relative())
+	if !strings.HasSuffix(slashed, blobExtension) {
+		return false
+	}
+	for _, dir := range []string{blobsDir, storeDir} {
+		if strings.HasPrefix(slashed, stateDir.Relative()+"/"+dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// PrivateFilePatterns returns gitattributes patterns matching private files.
+func PrivateFilePatterns() ([]string, error) {
+	patterns := []string{"*" + PrivateExtension}
+
+	settings, err := LoadSettings()
+	if err != nil {
+		return nil, err
+	}
+	storeDir, err := settings.Layout.StoreDir()
+	if err != nil {
+		return nil, err
+	}
+	if storeDir != "" {
+		patterns = append(patterns, StoreFilePattern(storeDir))
+	}
+
+	return patterns, nil
+}
+
+// StoreFilePattern returns the pattern matching the private files
+// in the given store directory.
+func StoreFilePattern(storeDir RepoRelativePath) string {
+	return filepath.ToSlash(storeDir.Relative()) + "/**/*" + blobExtension
+}
+
+// NewBlobName generates a random name for a private file in the blob layout.
+func NewBlobName() (string, error) {
+	name := make([]byte, blobNameSize)
+	_, err := rand.Read(name)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(name), nil
+}
+
+// FileListFile returns the path to the file holding the file list,
+// the plain text file list, or the encrypted index in the blob layout.
+func FileListFile() (AbsolutePath, error) {
+	settings, err := LoadSettings()
+	if err != nil {
+		return "", err
+	}
+	if settings.Layout == BlobLayout {
+		return IndexFile()
+	}
+	return PathsFile()
+}
+
+// The index is encrypted using a key derived from the hash key,
+// so that the file list can be updated without a private key.
+func indexCipher() (cipher.AEAD, error) {
+	hashKey, found, err := LoadCachedHashKey()
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		if source, err := hashKeySource(); err == nil && source == "" {
+			return nil, fmt.Errorf("the file index is encrypted, add a key first")
+		}
+		return nil, fmt.Errorf("%w, cannot access the encrypted file index", ErrNoHashKey)
+	}
+
+	key := make([]byte, chacha20poly1305.KeySize)
+	_, err = io.ReadFull(hkdf.New(sha256.New, hashKey, nil, []byte(indexKeyInfo)), key)
+	if err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.NewX(key)
+}
+
+// EncryptFileIndex encrypts the file list for storage in the index.
+func EncryptFileIndex(list FileList) ([]byte, error) {
+	aead, err := indexCipher()
+	if err != nil {
+		return nil, err
+	}
+
+	plain, err := json.Marshal(&list)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plain, nil), nil
+}
+
+// DecryptFileIndex decrypts a file list stored in the index.
+func DecryptFileIndex(data []byte) (FileList, error) {
+	aead, err := indexCipher()
+	if err != nil {
+		return FileList{}, err
+	}
+
+	if len(data) < aead.NonceSize() {
+		return FileList{}, fmt.Errorf("invalid file index")
+	}
+	nonce, encrypted := data[:aead.NonceSize()], data[aead.NonceSize():]
+
+	plain, err := aead.Open(nil, nonce, encrypted, nil)
+	if err != nil {
+		return FileList{}, fmt.Errorf("failed to decrypt file index")
+	}
+
+	var list FileList
+	err = json.Unmarshal(plain, &list)
+	return list, err
+}
+
+// LoadFileIndexFrom loads an encrypted file list from the given file.
+func LoadFileIndexFrom(file AbsolutePath) (FileList, error) {
+	data, err := os.ReadFile(file.Absolute())
+	if err != nil {
+		return FileList{}, err
+	}
+	return DecryptFileIndex(data)
+}
+
+// StoreFileIndexTo stores the file list encrypted in the given file.
+func StoreFileIndexTo(list FileList, file AbsolutePath) error {
+	if list.Version == 0 {
+		list.Version = 1
+	}
+	encrypted, err := EncryptFileIndex(list)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file.Absolute(), encrypted, 0600)
+}
+
+func loadFileIndex() (FileList, error) {
+	file, err := IndexFile()
+	if err != nil {
+		return FileList{}, err
+	}
+	exists, err := Exists(file)
+	if err != nil || !exists {
+		return FileList{}, err
+	}
+	return LoadFileIndexFrom(file)
+}
+
+func storeFileIndex(list FileList) error {
+	file, err := IndexFile()
+	if err != nil {
+		return err
+	}
+	return StoreFileIndexTo(list, file)
+}
@@ -0,0 +1,184 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// IsPattern checks if the given path contains glob characters.
+func IsPattern(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// AllFiles lists all files with entries in the list,
+// both tracked by path and by pattern.
+func (list FileList) AllFiles() []SecureFile {
+	files := append([]SecureFile{}, list.Files...)
+	for _, pattern := range list.Patterns {
+		files = append(files, pattern.Files...)
+	}
+	return files
+}
+
+// FindFile looks up the entry for the given file.
+func (list FileList) FindFile(path RepoRelativePath) (SecureFile, bool) {
+	for _, file := range list.AllFiles() {
+		if file.Path == path {
+			return file, true
+		}
+	}
+	return SecureFile{}, false
+}
+
+// NewFile returns a new entry for a file matching a tracked pattern,
+// in the format of the pattern.
+func (list FileList) NewFile(path RepoRelativePath) SecureFile {
+	file := SecureFile{Path: path}
+	if index := list.matchingPattern(path); index != -1 {
+		file.Format = list.Patterns[index].Format
+	}
+	return file
+}
+
+// Covers checks if the given file is tracked, by path or by pattern.
+func (list FileList) Covers(path RepoRelativePath) bool {
+	if _, found := list.FindFile(path); found {
+		return true
+	}
+	return list.matchingPattern(path) != -1
+}
+
+func (list FileList) matchingPattern(path RepoRelativePath) int {
+	if IsPrivateFile(path) {
+		return -1
+	}
+	for i, pattern := range list.Patterns {
+		if MatchPattern(pattern.Pattern, path) {
+			return i
+		}
+	}
+	return -1
+}
+
+// UpdateFile updates the entry of a tracked file.
+// Files matching a pattern get new entries as needed.
+func (list *FileList) UpdateFile(file SecureFile) error {
+	for i := range list.Files {
+		if list.Files[i].Path == file.Path {
+			list.Files[i] = file
+			return nil
+		}
+	}
+
+	for i := range list.Patterns {
+		pattern := &list.Patterns[i]
+		for j := range pattern.Files {
+			if pattern.Files[j].Path == file.Path {
+				pattern.Files[j] = file
+				return nil
+			}
+		}
+	}
+
+	index := list.matchingPattern(file.Path)
+	if index == -1 {
+		return fmt.Errorf("file %q not in file list", file.Path)
+	}
+	list.Patterns[index].Files = append(list.Patterns[index].Files, file)
+	return nil
+}
+
+// RetainPatternFiles removes entries for files matching patterns,
+// that are not in the given list. Returns true if any entries were removed.
+func (list *FileList) RetainPatternFiles(files []SecureFile) bool {
+	retain := map[RepoRelativePath]bool{}
+	for _, file := range files {
+		retain[file.Path] = true
+	}
+
+	removed := false
+	for i := range list.Patterns {
+		pattern := &list.Patterns[i]
+		var retained []SecureFile
+		for _, file := range pattern.Files {
+			if retain[file.Path] {
+				retained = append(retained, file)
+			} else {
+				removed = true
+			}
+		}
+		pattern.Files = retained
+	}
+	return removed
+}
+
+// ExpandFileList lists all tracked files, including files in the working tree
+// that match tracked patterns, but have not been hidden yet.
+// Hidden files matching patterns are left out if they no longer exist.
+func ExpandFileList(list FileList) ([]SecureFile, error) {
+	files := append([]SecureFile{}, list.Files...)
+
+	if len(list.Patterns) == 0 {
+		return files, nil
+	}
+
+	root, err := GetGitRootPath()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[RepoRelativePath]bool{}
+	for _, file := range files {
+		seen[file.Path] = true
+	}
+
+	for _, pattern := range list.Patterns {
+		for _, file := range pattern.Files {
+			if seen[file.Path] {
+				continue
+			}
+			plainExists, err := Exists(root.Join(file.Path))
+			if err != nil {
+				return nil, err
+			}
+			privatePath, err := PrivatePath(file)
+			if err != nil {
+				return nil, err
+			}
+			privateExists, err := Exists(root.Join(privatePath))
+			if err != nil {
+				return nil, err
+			}
+			if plainExists || privateExists {
+				seen[file.Path] = true
+				files = append(files, file)
+			}
+		}
+	}
+
+	worktreeFiles, err := GitListAllFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	stateDir, err := StateDir()
+	if err != nil {
+		return nil, err
+	}
+	stateDirRelative, err := RepoRelative(stateDir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range worktreeFiles {
+		if seen[path] || strings.HasPrefix(path.Relative(), stateDirRelative.Relative()+"/") {
+			continue
+		}
+		if list.matchingPattern(path) != -1 {
+			seen[path] = true
+			files = append(files, list.NewFile(path))
+		}
+	}
+
+	return files, nil
+}
@@ -0,0 +1,187 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Padding is the way plain text is padded before encryption,
+// to hide the size of private files.
+type Padding string
+
+const (
+	// NoPadding encrypts plain text as is.
+	NoPadding Padding = ""
+	// PowerOfTwoPadding pads to the next power of two.
+	PowerOfTwoPadding Padding = "pow2"
+	// BucketPadding pads to the next of a fixed set of sizes,
+	// and to whole megabytes above that.
+	BucketPadding Padding = "buckets"
+)
+
+// ParsePadding parses a padding scheme name, "none" meaning no padding.
+func ParsePadding(name string) (Padding, error) {
+	switch name {
+	case "", "none":
+		return NoPadding, nil
+	case string(PowerOfTwoPadding), string(BucketPadding):
+		return Padding(name), nil
+	default:
+		return "", fmt.Errorf("unknown padding %q, expected <none|pow2|buckets>", name)
+	}
+}
+
+const minPaddedSize = 256
+
+var paddingBuckets = []int{1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20}
+
+// paddedSize returns the size to pad a payload of the given size to.
+func (padding Padding) paddedSize(size int) int {
+	switch padding {
+	case PowerOfTwoPadding:
+		padded := minPaddedSize
+		for padded < size {
+			padded *= 2
+		}
+		return padded
+	case BucketPadding:
+		for _, bucket := range paddingBuckets {
+			if size <= bucket {
+				return bucket
+			}
+		}
+		last := paddingBuckets[len(paddingBuckets)-1]
+		return (size + last - 1) / last * last
+	default:
+		return size
+	}
+}
+
+// Payloads written with options start with a header, followed by
+// the content, possibly compressed, and any padding. The header holds
+// the options and the content length, so that the padding can be
+// stripped. Private files without the header, like files hidden before
+// padding was enabled, are plain text as is.
+var payloadMagic = []byte("\x00git-private")
+
+// Payload option flags
+const (
+	payloadPadded     byte = 1
+	payloadCompressed byte = 2
+)
+
+// Magic, flags and content length
+const payloadHeaderSize = 12 + 1 + 8
+
+// EncodePayload prepares plain text for encryption, compressing it if
+// that makes it smaller, and padding it using the given padding.
+func EncodePayload(plain []byte, compress bool, padding Padding) ([]byte, error) {
+	var flags byte
+	content := plain
+
+	if compress {
+		var buf bytes.Buffer
+		writer := gzip.NewWriter(&buf)
+		_, err := writer.Write(plain)
+		if err != nil {
+			return nil, err
+		}
+		err = writer.Close()
+		if err != nil {
+			return nil, err
+		}
+		if buf.Len() < len(plain) {
+			content = buf.Bytes()
+			flags |= payloadCompressed
+		}
+	}
+
+	if padding != NoPadding {
+		flags |= payloadPadded
+	}
+
+	// Plain text that looks like a header gets one, to be read back as is
+	if flags == 0 && !bytes.HasPrefix(plain, payloadMagic) {
+		return plain, nil
+	}
+
+	size := payloadHeaderSize + len(content)
+	payload := make([]byte, payloadHeaderSize, padding.paddedSize(size))
+	copy(payload, payloadMagic)
+	payload[len(payloadMagic)] = flags
+	binary.BigEndian.PutUint64(payload[len(payloadMagic)+1:], uint64(len(content)))
+	payload = append(payload, content...)
+
+	// Zero filled up to capacity
+	return payload[:cap(payload)], nil
+}
+
+// DecodePayload returns the plain text of a decrypted payload.
+func DecodePayload(payload []byte) ([]byte, error) {
+	reader, err := NewPayloadReader(bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(reader)
+}
+
+// NewPayloadReader returns a reader of the plain text of a decrypted payload,
+// for streaming decryption.
+func NewPayloadReader(payload io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(payload)
+	header, _ := buffered.Peek(payloadHeaderSize)
+	if !bytes.HasPrefix(header, payloadMagic) {
+		return buffered, nil
+	}
+	if len(header) < payloadHeaderSize {
+		return nil, fmt.Errorf("truncated payload header")
+	}
+
+	flags := header[len(payloadMagic)]
+	if flags&^(payloadPadded|payloadCompressed) != 0 {
+		return nil, fmt.Errorf("unsupported payload options %#x, upgrade git-private", flags)
+	}
+
+	length := binary.BigEndian.Uint64(header[len(payloadMagic)+1:])
+	if length > math.MaxInt64 {
+		return nil, fmt.Errorf("invalid payload length")
+	}
+	_, err := buffered.Discard(payloadHeaderSize)
+	if err != nil {
+		return nil, err
+	}
+
+	// Padding follows the content
+	var content io.Reader = &contentReader{reader: buffered, remaining: int64(length)}
+
+	if flags&payloadCompressed != 0 {
+		return gzip.NewReader(content)
+	}
+	return content, nil
+}
+
+// contentReader reads the given number of bytes, failing if there are less.
+type contentReader struct {
+	reader    io.Reader
+	remaining int64
+}
+
+func (cr *contentReader) Read(p []byte) (int, error) {
+	if cr.remaining == 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > cr.remaining {
+		p = p[:cr.remaining]
+	}
+	n, err := cr.reader.Read(p)
+	cr.remaining -= int64(n)
+	if err == io.EOF && cr.remaining != 0 {
+		err = fmt.Errorf("truncated payload")
+	}
+	return n, err
+}
@@ -2,10 +2,14 @@ package utils
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 
 	"filippo.io/age"
@@ -31,18 +35,87 @@ type KeyList struct {
 	Keys    []Key
 }
 
+// SecureFile is a file to keep private. Hash is the hash of the plain
+// text, and PrivateHash the hash of the private file, when last hidden.
+// RecipientsHash identifies the keys the private file was encrypted to.
+// Blob is the name of the private file in the blob layout.
+// Format is the format of structured files, encrypted value by value.
 type SecureFile struct {
-	Path RepoRelativePath
-	Hash string
+	Path           RepoRelativePath
+	Hash           string
+	PrivateHash    string `json:",omitempty"`
+	RecipientsHash string `json:",omitempty"`
+	Blob           string `json:",omitempty"`
+	Format         Format `json:",omitempty"`
+}
+
+// SecurePattern is a pattern of files to keep private.
+// Files keeps entries for matching files that have been hidden.
+// Format is the format of matching files.
+type SecurePattern struct {
+	Pattern string
+	Files   []SecureFile
+	Format  Format `json:",omitempty"`
 }
 
 type FileList struct {
+	Version  int
+	Files    []SecureFile
+	Patterns []SecurePattern `json:",omitempty"`
+}
+
+// Layout is the way private files are stored in the repo.
+type Layout string
+
+const (
+	// SiblingLayout stores private files next to the original files.
+	SiblingLayout Layout = ""
+	// BlobLayout stores private files under random names in the blobs
+	// directory, and the file list in an encrypted index.
+	BlobLayout Layout = "blobs"
+	// MirrorLayout stores private files in the store directory,
+	// mirroring the paths of the original files.
+	MirrorLayout Layout = "mirror"
+)
+
+// Settings are repo wide options, shared by all users.
+// Envelope mode encrypts files to a generated data key, which is in turn
+// encrypted to all keys in the key list.
+// Padding hides the size of files hidden from then on.
+// Armor stores private files in the armored text format.
+// Compress compresses files before encryption, from then on.
+type Settings struct {
+	Version  int
+	Envelope bool
+	Layout   Layout  `json:",omitempty"`
+	Padding  Padding `json:",omitempty"`
+	Armor    bool    `json:",omitempty"`
+	Compress bool    `json:",omitempty"`
+}
+
+// SyncState is the local record of the plain text and private file hashes
+// of files, from when they were last hidden or revealed in the work tree.
+type SyncState struct {
 	Version int
 	Files   []SecureFile
 }
 
+// ScanRule flags files with matching paths and / or contents
+// as likely secrets. Path is a gitignore style pattern and Content
+// is a regular expression.
+type ScanRule struct {
+	Name    string
+	Path    string
+	Content string
+}
+
+type ScanRules struct {
+	Version int
+	Rules   []ScanRule
+	Ignore  []string
+}
+
 func LoadKeyList(identity age.Identity) (KeyList, error) {
-	var list KeyList
 	file, err := KeysFile()
 	if err != nil {
 		return KeyList{}, err
@@ -63,6 +136,13 @@ func LoadKeyList(identity age.Identity) (KeyList, error) {
 	}
 	defer reader.Close()
 
+	return DecryptKeyList(reader, identity)
+}
+
+// DecryptKeyList reads an encrypted key list from the given reader.
+func DecryptKeyList(reader io.Reader, identity age.Identity) (KeyList, error) {
+	var list KeyList
+
 	decrypted, err := age.Decrypt(reader, identity)
 	if err != nil {
 		return KeyList{}, fmt.Errorf("key list decryption failed")
@@ -73,6 +153,17 @@ func LoadKeyList(identity age.Identity) (KeyList, error) {
 }
 
 func StoreKeyList(identity age.Identity, list KeyList) error {
+	file, err := KeysFile()
+	if err != nil {
+		return err
+	}
+
+	return StoreKeyListTo(identity, list, file)
+}
+
+// StoreKeyListTo stores the key list in the given file, encrypted
+// using the read/write keys in the list.
+func StoreKeyListTo(identity age.Identity, list KeyList, file AbsolutePath) error {
 	// Make sure the current user has access to the key list before replacing it
 	_, err := GetRecipients(identity)
 	if err != nil {
@@ -89,11 +180,6 @@ func StoreKeyList(identity age.Identity, list KeyList) error {
 		return fmt.Errorf("cannot update key list, no keys with read/write access")
 	}
 
-	file, err := KeysFile()
-	if err != nil {
-		return err
-	}
-
 	var buf bytes.Buffer
 
 	encrypted, err := age.Encrypt(&buf, recipients...)
@@ -121,24 +207,329 @@ func StoreKeyList(identity age.Identity, list KeyList) error {
 }
 
 func LoadFileList() (FileList, error) {
+	settings, err := LoadSettings()
+	if err != nil {
+		return FileList{}, err
+	}
+	if settings.Layout == BlobLayout {
+		return loadFileIndex()
+	}
+
 	file, err := PathsFile()
 	if err != nil {
 		return FileList{}, err
 	}
+	return LoadFileListFrom(file)
+}
+
+// LoadFileListFrom loads a file list from the given file.
+func LoadFileListFrom(file AbsolutePath) (FileList, error) {
+	var list FileList
+	err := load(file, &list)
+	return list, err
+}
+
+// ParseFileList parses a file list from the given data.
+func ParseFileList(data []byte) (FileList, error) {
 	var list FileList
-	err = load(file, &list)
+	err := json.Unmarshal(data, &list)
 	return list, err
 }
 
 func StoreFileList(list FileList) error {
+	settings, err := LoadSettings()
+	if err != nil {
+		return err
+	}
+	if settings.Layout == BlobLayout {
+		return storeFileIndex(list)
+	}
+
 	file, err := PathsFile()
 	if err != nil {
 		return err
 	}
-	list.Version = 1
+	return StoreFileListTo(list, file)
+}
+
+// StoreFileListTo stores the file list in the given file.
+// Lists without a version are stored as version 1.
+func StoreFileListTo(list FileList, file AbsolutePath) error {
+	if list.Version == 0 {
+		list.Version = 1
+	}
 	return store(file, &list)
 }
 
+// LoadSettings loads the repo settings, using defaults
+// for repos without settings.
+func LoadSettings() (Settings, error) {
+	file, err := SettingsFile()
+	if err != nil {
+		return Settings{}, err
+	}
+
+	exists, err := Exists(file)
+	if err != nil || !exists {
+		return Settings{}, err
+	}
+
+	var settings Settings
+	err = load(file, &settings)
+	return settings, err
+}
+
+// ParseSettings parses repo settings from the given data.
+func ParseSettings(data []byte) (Settings, error) {
+	var settings Settings
+	err := json.Unmarshal(data, &settings)
+	return settings, err
+}
+
+func StoreSettings(settings Settings) error {
+	file, err := SettingsFile()
+	if err != nil {
+		return err
+	}
+	settings.Version = 1
+	return store(file, &settings)
+}
+
+// LoadDataKey loads the data key used in envelope mode.
+// Returns false if there is no data key.
+func LoadDataKey(identity age.Identity) (*age.X25519Identity, bool, error) {
+	dataKeys, found, err := LoadDataKeys(identity)
+	if err != nil || !found {
+		return nil, false, err
+	}
+	return dataKeys[0], true, nil
+}
+
+// LoadDataKeys loads the data key, followed by any previous data keys,
+// kept while private files are re-encrypted after replacing the data key.
+// Returns false if there is no data key.
+func LoadDataKeys(identity age.Identity) ([]*age.X25519Identity, bool, error) {
+	file, err := DataKeyFile()
+	if err != nil {
+		return nil, false, err
+	}
+
+	keyData, found, err := loadWrappedSecret(file, identity)
+	if err != nil || !found {
+		return nil, false, err
+	}
+
+	dataKeys, err := parseDataKeys(keyData)
+	if err != nil {
+		return nil, false, err
+	}
+	return dataKeys, true, nil
+}
+
+// DecryptDataKeys decrypts the contents of a data key file, for example
+// as read from an earlier revision.
+func DecryptDataKeys(data []byte, identity age.Identity) ([]*age.X25519Identity, error) {
+	keyData, err := UnwrapSecret(bytes.NewReader(data), identity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt data key")
+	}
+	return parseDataKeys(keyData)
+}
+
+// parseDataKeys parses data keys stored one per line.
+func parseDataKeys(keyData []byte) ([]*age.X25519Identity, error) {
+	var dataKeys []*age.X25519Identity
+	for _, line := range strings.Fields(string(keyData)) {
+		dataKey, err := age.ParseX25519Identity(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid data key: %w", err)
+		}
+		dataKeys = append(dataKeys, dataKey)
+	}
+	if len(dataKeys) == 0 {
+		return nil, fmt.Errorf("invalid data key: no key found")
+	}
+	return dataKeys, nil
+}
+
+// StoreDataKey stores the data key, and any previous data keys that files
+// might still be encrypted to, encrypted to all keys in the key list.
+func StoreDataKey(identity age.Identity, dataKey *age.X25519Identity, previous ...*age.X25519Identity) error {
+	file, err := DataKeyFile()
+	if err != nil {
+		return err
+	}
+
+	var keyData strings.Builder
+	for _, key := range append([]*age.X25519Identity{dataKey}, previous...) {
+		keyData.WriteString(key.String() + "\n")
+	}
+	return storeWrappedSecret(file, identity, []byte(keyData.String()))
+}
+
+// loadWrappedSecret loads a secret stored encrypted to all keys.
+// Returns false if the file does not exist.
+func loadWrappedSecret(file AbsolutePath, identity age.Identity) ([]byte, bool, error) {
+	exists, err := Exists(file)
+	if err != nil || !exists {
+		return nil, false, err
+	}
+
+	reader, err := file.Open()
+	if err != nil {
+		return nil, false, err
+	}
+	defer reader.Close()
+
+	secret, err := UnwrapSecret(reader, identity)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to decrypt %q", filepath.Base(file.Absolute()))
+	}
+	return secret, true, nil
+}
+
+// UnwrapSecret decrypts a secret stored encrypted to all keys,
+// like the data key or the hash key.
+func UnwrapSecret(reader io.Reader, identity age.Identity) ([]byte, error) {
+	decrypted, err := age.Decrypt(reader, identity)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(decrypted)
+}
+
+// storeWrappedSecret stores a secret encrypted to all keys in the key list.
+func storeWrappedSecret(file AbsolutePath, identity age.Identity, secret []byte) error {
+	recipients, err := GetRecipients(identity)
+	if err != nil {
+		return err
+	}
+	if len(recipients) == 0 {
+		return fmt.Errorf("cannot store %q, no keys added", filepath.Base(file.Absolute()))
+	}
+
+	var buf bytes.Buffer
+
+	encrypted, err := age.Encrypt(&buf, recipients...)
+	if err != nil {
+		return err
+	}
+	_, err = encrypted.Write(secret)
+	if err != nil {
+		return err
+	}
+	err = encrypted.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(file.Absolute(), buf.Bytes(), 0600)
+}
+
+// DataKeyFingerprint identifies the data key, as the single
+// recipient of files in envelope mode.
+func DataKeyFingerprint(dataKey *age.X25519Identity) string {
+	sum := sha256.Sum256([]byte("datakey:" + dataKey.Recipient().String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// LoadSyncState loads the local record of synced files.
+func LoadSyncState() (SyncState, error) {
+	file, err := SyncStateFile()
+	if err != nil {
+		return SyncState{}, err
+	}
+
+	exists, err := Exists(file)
+	if err != nil || !exists {
+		return SyncState{}, err
+	}
+
+	var state SyncState
+	err = load(file, &state)
+	return state, err
+}
+
+func StoreSyncState(state SyncState) error {
+	file, err := SyncStateFile()
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(file.Absolute()), 0770)
+	if err != nil {
+		return err
+	}
+	state.Version = 1
+	return store(file, &state)
+}
+
+// FindFile looks up the synced state of the given file.
+func (state SyncState) FindFile(path RepoRelativePath) (SecureFile, bool) {
+	for _, file := range state.Files {
+		if file.Path == path {
+			return file, true
+		}
+	}
+	return SecureFile{}, false
+}
+
+// RecordSynced records that the plain text and private versions
+// of the file are in sync.
+func RecordSynced(file SecureFile) error {
+	state, err := LoadSyncState()
+	if err != nil {
+		return err
+	}
+
+	var files []SecureFile
+	for _, synced := range state.Files {
+		if synced.Path != file.Path {
+			files = append(files, synced)
+		}
+	}
+	state.Files = append(files, file)
+
+	return StoreSyncState(state)
+}
+
+// ForgetSynced removes the sync record of the given file.
+func ForgetSynced(path RepoRelativePath) error {
+	state, err := LoadSyncState()
+	if err != nil {
+		return err
+	}
+
+	var files []SecureFile
+	for _, synced := range state.Files {
+		if synced.Path != path {
+			files = append(files, synced)
+		}
+	}
+	if len(files) == len(state.Files) {
+		return nil
+	}
+	state.Files = files
+
+	return StoreSyncState(state)
+}
+
+// LoadScanRules loads optional, user defined scan rules.
+func LoadScanRules() (ScanRules, error) {
+	file, err := ScanRulesFile()
+	if err != nil {
+		return ScanRules{}, err
+	}
+
+	exists, err := Exists(file)
+	if err != nil || !exists {
+		return ScanRules{}, err
+	}
+
+	var rules ScanRules
+	err = load(file, &rules)
+	return rules, err
+}
+
 func loadFrom(reader io.Reader, dest interface{}) error {
 	bytes, err := io.ReadAll(reader)
 	if err != nil {
@@ -231,6 +622,28 @@ func getRecipientsFromKeylist(keyList KeyList, access KeyAccess) ([]age.Recipien
 	return recipients, nil
 }
 
+// Fingerprint identifies the set of keys in the list,
+// independent of order, IDs and access.
+func (list KeyList) Fingerprint() string {
+	var keys []string
+	for _, key := range list.Keys {
+		keys = append(keys, fmt.Sprintf("%s:%s", key.Type, strings.TrimSpace(key.Key)))
+	}
+	sort.Strings(keys)
+
+	sum := sha256.Sum256([]byte(strings.Join(keys, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// GetRecipientsFingerprint returns the fingerprint of the current key list.
+func GetRecipientsFingerprint(identity age.Identity) (string, error) {
+	keyList, err := LoadKeyList(identity)
+	if err != nil {
+		return "", err
+	}
+	return keyList.Fingerprint(), nil
+}
+
 func GetRecipients(identity age.Identity) ([]age.Recipient, error) {
 	keyList, err := LoadKeyList(identity)
 	if err != nil {
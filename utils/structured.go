@@ -0,0 +1,603 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// Format is the format of a private file. Whole files are encrypted
+// as one. Structured formats keep keys and structure in plain text,
+// and encrypt values one by one.
+type Format string
+
+const (
+	// WholeFile encrypts the file as a whole.
+	WholeFile Format = ""
+	// DotenvFormat encrypts the values of KEY=value lines.
+	DotenvFormat Format = "dotenv"
+	// JSONFormat encrypts the string, number and boolean values of a JSON document.
+	JSONFormat Format = "json"
+	// YAMLFormat encrypts the scalar values of a block style YAML document.
+	YAMLFormat Format = "yaml"
+)
+
+// ParseFormat parses a file format name, "whole" meaning the whole file.
+func ParseFormat(name string) (Format, error) {
+	switch name {
+	case "", "whole":
+		return WholeFile, nil
+	case string(DotenvFormat), string(JSONFormat), string(YAMLFormat):
+		return Format(name), nil
+	default:
+		return "", fmt.Errorf("unknown format %q, expected <whole|dotenv|json|yaml>", name)
+	}
+}
+
+// Value is a value in a structured file, found at data[Start:End],
+// including any quotes. Path identifies the value by the keys and
+// list indices leading to it.
+type Value struct {
+	Path  string
+	Start int
+	End   int
+}
+
+// Values returns the values of a structured file, in file order.
+// Constructs that cannot be parsed are reported as errors,
+// rather than left unencrypted.
+func (format Format) Values(data []byte) ([]Value, error) {
+	switch format {
+	case DotenvFormat:
+		return dotenvValues(data)
+	case JSONFormat:
+		return jsonValues(data)
+	case YAMLFormat:
+		return yamlValues(data)
+	default:
+		return nil, fmt.Errorf("%q is not a structured format", format)
+	}
+}
+
+type line struct {
+	number int
+	start  int
+	end    int
+}
+
+// splitLines returns the lines of data, excluding line endings.
+func splitLines(data []byte) []line {
+	var lines []line
+	start := 0
+	for start < len(data) {
+		end := bytes.IndexByte(data[start:], '\n')
+		next := start + end + 1
+		if end < 0 {
+			end = len(data) - start
+			next = len(data)
+		}
+		end += start
+		if end > start && data[end-1] == '\r' {
+			end--
+		}
+		lines = append(lines, line{number: len(lines) + 1, start: start, end: end})
+		start = next
+	}
+	return lines
+}
+
+func dotenvValues(data []byte) ([]Value, error) {
+	var values []Value
+	lines := splitLines(data)
+
+	for i := 0; i < len(lines); i++ {
+		current := lines[i]
+		text := string(data[current.start:current.end])
+		trimmed := strings.TrimSpace(text)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		separator := strings.IndexByte(text, '=')
+		if separator < 0 {
+			return nil, fmt.Errorf("line %d: expected KEY=value", current.number)
+		}
+		key := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(text[:separator]), "export "))
+		if key == "" {
+			return nil, fmt.Errorf("line %d: missing key", current.number)
+		}
+
+		start := current.start + separator + 1
+		end := current.end
+		if start == end {
+			continue
+		}
+
+		// Quoted values may span lines
+		if quote := data[start]; quote == '"' || quote == '\'' {
+			for strings.Count(string(data[start+1:end]), string(quote))-strings.Count(string(data[start+1:end]), `\`+string(quote)) == 0 {
+				i++
+				if i == len(lines) {
+					return nil, fmt.Errorf("line %d: unterminated quoted value", current.number)
+				}
+				end = lines[i].end
+			}
+		}
+
+		values = append(values, Value{Path: key, Start: start, End: end})
+	}
+
+	return values, nil
+}
+
+func jsonValues(data []byte) ([]Value, error) {
+	type container struct {
+		object    bool
+		path      string
+		key       string
+		expectKey bool
+		index     int
+	}
+
+	var values []Value
+	var stack []*container
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	childPath := func() string {
+		if len(stack) == 0 {
+			return ""
+		}
+		parent := stack[len(stack)-1]
+		if parent.object {
+			if parent.path == "" {
+				return parent.key
+			}
+			return parent.path + "." + parent.key
+		}
+		return fmt.Sprintf("%s[%d]", parent.path, parent.index)
+	}
+
+	valueDone := func() {
+		if len(stack) == 0 {
+			return
+		}
+		parent := stack[len(stack)-1]
+		if parent.object {
+			parent.expectKey = true
+		} else {
+			parent.index++
+		}
+	}
+
+	offset := 0
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			if len(stack) != 0 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// Tokens are separated by white space, commas and colons
+		start := offset
+		for start < len(data) && strings.IndexByte(" \t\r\n,:", data[start]) >= 0 {
+			start++
+		}
+		end := int(decoder.InputOffset())
+		offset = end
+
+		switch token := token.(type) {
+		case json.Delim:
+			switch token {
+			case '{', '[':
+				stack = append(stack, &container{
+					object:    token == '{',
+					path:      childPath(),
+					expectKey: token == '{',
+				})
+			default:
+				stack = stack[:len(stack)-1]
+				valueDone()
+			}
+		case string:
+			if len(stack) != 0 && stack[len(stack)-1].expectKey {
+				top := stack[len(stack)-1]
+				top.key = token
+				top.expectKey = false
+				continue
+			}
+			values = append(values, Value{Path: childPath(), Start: start, End: end})
+			valueDone()
+		case nil:
+			valueDone()
+		default:
+			values = append(values, Value{Path: childPath(), Start: start, End: end})
+			valueDone()
+		}
+	}
+
+	return values, nil
+}
+
+func yamlValues(data []byte) ([]Value, error) {
+	type frame struct {
+		indent int
+		path   string
+		key    bool
+		items  int
+	}
+
+	var values []Value
+	stack := []*frame{{indent: -1}}
+	lines := splitLines(data)
+
+	for i := 0; i < len(lines); i++ {
+		current := lines[i]
+		text := string(data[current.start:current.end])
+		content := strings.TrimLeft(text, " ")
+		indent := len(text) - len(content)
+
+		if strings.TrimSpace(content) == "" || strings.HasPrefix(content, "#") {
+			continue
+		}
+		if indent == 0 && (strings.HasPrefix(content, "---") || strings.HasPrefix(content, "...") || strings.HasPrefix(content, "%")) {
+			continue
+		}
+		if strings.HasPrefix(content, "\t") {
+			return nil, fmt.Errorf("line %d: tabs cannot be used for indentation", current.number)
+		}
+
+		item := content == "-" || strings.HasPrefix(content, "- ")
+
+		for len(stack) > 1 {
+			top := stack[len(stack)-1]
+			if top.indent < indent || (top.indent == indent && top.key && item) {
+				break
+			}
+			stack = stack[:len(stack)-1]
+		}
+		parent := stack[len(stack)-1]
+
+		column := indent
+		path := parent.path
+
+		if item {
+			path = fmt.Sprintf("%s[%d]", parent.path, parent.items)
+			parent.items++
+
+			rest := strings.TrimLeft(strings.TrimPrefix(content, "-"), " ")
+			column = indent + len(content) - len(rest)
+			content = rest
+
+			itemFrame := &frame{indent: indent, path: path}
+			if content == "" || strings.HasPrefix(content, "#") {
+				stack = append(stack, itemFrame)
+				continue
+			}
+			if _, _, found := splitYAMLKey(content); found {
+				// Mapping in a list item, keys indented to the content
+				itemFrame.indent = column - 1
+				stack = append(stack, itemFrame)
+				parent = itemFrame
+			} else {
+				start := current.start + column
+				end, next, err := yamlScalarEnd(data, lines, i, start, indent)
+				if err != nil {
+					return nil, err
+				}
+				values = append(values, Value{Path: path, Start: start, End: end})
+				i = next
+				continue
+			}
+		}
+
+		key, valueOffset, found := splitYAMLKey(content)
+		if !found {
+			return nil, fmt.Errorf("line %d: expected key, list item or block scalar", current.number)
+		}
+		if parent.path != "" {
+			path = parent.path + "." + key
+		} else {
+			path = key
+		}
+
+		rest := content[valueOffset:]
+		value := strings.TrimLeft(rest, " ")
+		if value == "" || strings.HasPrefix(value, "#") {
+			stack = append(stack, &frame{indent: column, path: path, key: true})
+			continue
+		}
+
+		start := current.start + column + valueOffset + len(rest) - len(value)
+		end, next, err := yamlScalarEnd(data, lines, i, start, column)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, Value{Path: path, Start: start, End: end})
+		i = next
+	}
+
+	return values, nil
+}
+
+// splitYAMLKey splits a "key: value" line, returning the unquoted key
+// and the offset of the value.
+func splitYAMLKey(content string) (string, int, bool) {
+	// Flow collections, anchors, aliases, tags and block scalars
+	if content == "" || strings.IndexByte("[{&*!|>", content[0]) >= 0 {
+		return "", 0, false
+	}
+
+	keyEnd := 0
+	if quote := content[0]; quote == '\'' || quote == '"' {
+		closing := strings.IndexByte(content[1:], quote)
+		if closing < 0 {
+			return "", 0, false
+		}
+		keyEnd = closing + 2
+		if keyEnd == len(content) || content[keyEnd] != ':' {
+			return "", 0, false
+		}
+	} else {
+		for {
+			colon := strings.IndexByte(content[keyEnd:], ':')
+			if colon < 0 {
+				return "", 0, false
+			}
+			keyEnd += colon
+			if keyEnd+1 == len(content) || content[keyEnd+1] == ' ' {
+				break
+			}
+			keyEnd++
+		}
+		if strings.Contains(content[:keyEnd], " #") {
+			return "", 0, false
+		}
+	}
+
+	key := strings.TrimSpace(content[:keyEnd])
+	key = strings.Trim(key, `'"`)
+	return key, keyEnd + 1, true
+}
+
+// yamlScalarEnd finds the end of the scalar starting at start on the given
+// line, leaving out trailing comments. Block scalars continue on following
+// lines indented beyond the given indent. Returns the end offset, and the
+// index of the last line of the scalar.
+func yamlScalarEnd(data []byte, lines []line, index int, start int, indent int) (int, int, error) {
+	current := lines[index]
+	text := string(data[start:current.end])
+
+	if text[0] == '|' || text[0] == '>' {
+		end := current.end
+		for index+1 < len(lines) {
+			next := lines[index+1]
+			nextText := string(data[next.start:next.end])
+			nextContent := strings.TrimLeft(nextText, " ")
+			if nextContent != "" && len(nextText)-len(nextContent) <= indent {
+				break
+			}
+			index++
+			if nextContent != "" {
+				end = next.end
+			}
+		}
+		return end, index, nil
+	}
+
+	end := len(text)
+	switch quote := text[0]; quote {
+	case '"', '\'':
+		closing := 1
+		for {
+			found := strings.IndexByte(text[closing:], quote)
+			if found < 0 {
+				return 0, 0, fmt.Errorf("line %d: quoted values spanning lines are not supported", current.number)
+			}
+			closing += found
+			if quote == '"' && text[closing-1] == '\\' {
+				closing++
+				continue
+			}
+			if quote == '\'' && closing+1 < len(text) && text[closing+1] == '\'' {
+				closing += 2
+				continue
+			}
+			break
+		}
+		end = closing + 1
+	default:
+		if comment := strings.Index(text, " #"); comment >= 0 {
+			end = comment
+		}
+	}
+
+	value := strings.TrimRight(text[:end], " ")
+
+	// Plain scalars continued on following lines are not supported
+	if index+1 < len(lines) {
+		next := lines[index+1]
+		nextText := string(data[next.start:next.end])
+		nextContent := strings.TrimLeft(nextText, " ")
+		if nextContent != "" && !strings.HasPrefix(nextContent, "#") && len(nextText)-len(nextContent) > indent {
+			if _, _, found := splitYAMLKey(nextContent); !found && !strings.HasPrefix(nextContent, "- ") && nextContent != "-" {
+				return 0, 0, fmt.Errorf("line %d: values spanning lines are not supported", next.number)
+			}
+		}
+	}
+
+	return start + len(value), index, nil
+}
+
+// GuessFormat guesses the structured format of a file from its name,
+// for files encrypted as a whole.
+func GuessFormat(path RepoRelativePath) (Format, bool) {
+	name := strings.ToLower(filepath.Base(path.Relative()))
+	switch {
+	case strings.HasSuffix(name, ".json"):
+		return JSONFormat, true
+	case strings.HasSuffix(name, ".yaml"), strings.HasSuffix(name, ".yml"):
+		return YAMLFormat, true
+	case name == ".env", strings.HasPrefix(name, ".env."), strings.HasSuffix(name, ".env"):
+		return DotenvFormat, true
+	default:
+		return WholeFile, false
+	}
+}
+
+// FindValue finds the value with the given path in a structured file.
+func (format Format) FindValue(data []byte, path string) (Value, bool, error) {
+	values, err := format.Values(data)
+	if err != nil {
+		return Value{}, false, err
+	}
+	for _, value := range values {
+		if value.Path == path {
+			return value, true, nil
+		}
+	}
+	return Value{}, false, nil
+}
+
+// DecodeValue returns the value represented by the raw text of a value,
+// without quotes and escapes.
+func (format Format) DecodeValue(raw []byte) (string, error) {
+	text := string(raw)
+
+	switch format {
+	case JSONFormat:
+		if strings.HasPrefix(text, `"`) {
+			var value string
+			err := json.Unmarshal(raw, &value)
+			return value, err
+		}
+		return text, nil
+
+	case DotenvFormat:
+		switch {
+		case strings.HasPrefix(text, `"`):
+			end := strings.LastIndexByte(text, '"')
+			if end < 1 {
+				return "", fmt.Errorf("unterminated quoted value")
+			}
+			replacer := strings.NewReplacer(`\n`, "\n", `\"`, `"`, `\\`, `\`)
+			return replacer.Replace(text[1:end]), nil
+		case strings.HasPrefix(text, "'"):
+			end := strings.LastIndexByte(text, '\'')
+			if end < 1 {
+				return "", fmt.Errorf("unterminated quoted value")
+			}
+			return text[1:end], nil
+		default:
+			if comment := strings.Index(text, " #"); comment >= 0 {
+				text = text[:comment]
+			}
+			return strings.TrimSpace(text), nil
+		}
+
+	case YAMLFormat:
+		switch {
+		case strings.HasPrefix(text, `"`):
+			var value string
+			err := json.Unmarshal(raw, &value)
+			return value, err
+		case strings.HasPrefix(text, "'"):
+			return strings.ReplaceAll(text[1:len(text)-1], "''", "'"), nil
+		case strings.HasPrefix(text, "|"), strings.HasPrefix(text, ">"):
+			return decodeBlockScalar(text), nil
+		default:
+			return text, nil
+		}
+
+	default:
+		return "", fmt.Errorf("%q is not a structured format", format)
+	}
+}
+
+// decodeBlockScalar decodes a literal or folded YAML block scalar,
+// using the default chomping of a single trailing line break.
+func decodeBlockScalar(text string) string {
+	lines := strings.Split(text, "\n")
+	folded := strings.HasPrefix(lines[0], ">")
+	lines = lines[1:]
+
+	indent := -1
+	for _, line := range lines {
+		content := strings.TrimLeft(line, " ")
+		if content == "" {
+			continue
+		}
+		if lineIndent := len(line) - len(content); indent < 0 || lineIndent < indent {
+			indent = lineIndent
+		}
+	}
+	for i, line := range lines {
+		if len(line) >= indent && indent > 0 {
+			lines[i] = strings.TrimRight(line[indent:], "\r")
+		} else {
+			lines[i] = strings.TrimSpace(line)
+		}
+	}
+
+	if folded {
+		return strings.Join(lines, " ") + "\n"
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// EncodeValue returns the raw text of a value, quoted as needed.
+// Values replacing JSON numbers or booleans, or plain YAML scalars,
+// are written as is if valid, keeping their type.
+func (format Format) EncodeValue(value string, previousRaw []byte) []byte {
+	switch format {
+	case JSONFormat:
+		if len(previousRaw) != 0 && previousRaw[0] != '"' && json.Valid([]byte(value)) && !strings.ContainsAny(value, "{[\"") {
+			return []byte(value)
+		}
+		encoded, _ := json.Marshal(value)
+		return encoded
+
+	case DotenvFormat:
+		if value != "" && !strings.ContainsAny(value, " \t\n\r#\"'\\$`=") {
+			return []byte(value)
+		}
+		// Loaders expand variables in double quotes
+		if strings.ContainsAny(value, "$`") && !strings.Contains(value, "'") {
+			return []byte("'" + value + "'")
+		}
+		replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+		return []byte(`"` + replacer.Replace(value) + `"`)
+
+	default:
+		quoted := len(previousRaw) != 0 && (previousRaw[0] == '"' || previousRaw[0] == '\'')
+		if !quoted && isPlainYAMLScalar(value) {
+			return []byte(value)
+		}
+		encoded, _ := json.Marshal(value)
+		return encoded
+	}
+}
+
+// isPlainYAMLScalar checks if a value can be written unquoted in YAML,
+// and still be read back as the same string.
+func isPlainYAMLScalar(value string) bool {
+	if value == "" || strings.TrimSpace(value) != value {
+		return false
+	}
+	if strings.IndexByte("-?:,[]{}#&*!|>'\"%@`~", value[0]) >= 0 {
+		return false
+	}
+	if strings.ContainsAny(value, "\n\r\t") || strings.Contains(value, ": ") || strings.Contains(value, " #") || strings.HasSuffix(value, ":") {
+		return false
+	}
+	return true
+}